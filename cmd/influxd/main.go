@@ -89,15 +89,29 @@ func (m *Main) Run(args ...string) error {
 
 		signalCh := make(chan os.Signal, 1)
 		signal.Notify(signalCh, os.Interrupt, syscall.SIGTERM)
+
+		// SIGHUP reloads the config and TLS certificates instead of stopping
+		// the server.
+		hupCh := make(chan os.Signal, 1)
+		signal.Notify(hupCh, syscall.SIGHUP)
 		m.Logger.Println("Listening for signals")
 
-		// Block until one of the signals above is received
-		select {
-		case <-signalCh:
-			m.Logger.Println("Signal received, initializing clean shutdown...")
-			go func() {
-				cmd.Close()
-			}()
+		// Block until one of the shutdown signals above is received
+	wait:
+		for {
+			select {
+			case <-hupCh:
+				m.Logger.Println("SIGHUP received, reloading config and TLS certificates")
+				if err := cmd.Reload(); err != nil {
+					m.Logger.Printf("Reload failed: %s", err)
+				}
+			case <-signalCh:
+				m.Logger.Println("Signal received, initializing clean shutdown...")
+				go func() {
+					cmd.Close()
+				}()
+				break wait
+			}
 		}
 
 		// Block again until another signal is received, a shutdown timeout elapses,
@@ -1,6 +1,7 @@
 package run
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -43,6 +44,9 @@ type Command struct {
 	Stderr io.Writer
 
 	Server *Server
+
+	// options are the flags the server was run with, kept for Reload.
+	options Options
 }
 
 // NewCommand return a new instance of Command.
@@ -83,29 +87,10 @@ func (cmd *Command) Run(args ...string) error {
 	// Turn on block profiling to debug stuck databases
 	runtime.SetBlockProfileRate(int(1 * time.Second))
 
-	// Parse config
-	config, err := cmd.ParseConfig(options.ConfigPath)
+	// Parse and validate the config.
+	config, err := cmd.loadConfig(options)
 	if err != nil {
-		return fmt.Errorf("parse config: %s", err)
-	}
-
-	// Apply any environment variables on top of the parsed config
-	if err := config.ApplyEnvOverrides(); err != nil {
-		return fmt.Errorf("apply env config: %v", err)
-	}
-
-	// Override config hostname if specified in the command line args.
-	if options.Hostname != "" {
-		config.Meta.Hostname = options.Hostname
-	}
-
-	if options.Join != "" {
-		config.Meta.Peers = strings.Split(options.Join, ",")
-	}
-
-	// Validate the configuration.
-	if err := config.Validate(); err != nil {
-		return fmt.Errorf("%s. To generate a valid configuration file run `influxd config > influxdb.generated.conf`", err)
+		return err
 	}
 
 	// Create server from config and start it.
@@ -125,6 +110,7 @@ func (cmd *Command) Run(args ...string) error {
 		return fmt.Errorf("open server: %s", err)
 	}
 	cmd.Server = s
+	cmd.options = options
 
 	// Begin monitoring the server's error channel.
 	go cmd.monitorServerErrors()
@@ -132,6 +118,50 @@ func (cmd *Command) Run(args ...string) error {
 	return nil
 }
 
+// loadConfig parses the config file named by options, applies environment
+// variables and command line overrides, and validates the result.
+func (cmd *Command) loadConfig(options Options) (*Config, error) {
+	// Parse config
+	config, err := cmd.parseConfig(options.ConfigPath, options.StrictConfig)
+	if err != nil {
+		return nil, fmt.Errorf("parse config: %s", err)
+	}
+
+	// Apply any environment variables on top of the parsed config
+	if err := config.ApplyEnvOverrides(); err != nil {
+		return nil, fmt.Errorf("apply env config: %v", err)
+	}
+
+	// Override config hostname if specified in the command line args.
+	if options.Hostname != "" {
+		config.Meta.Hostname = options.Hostname
+	}
+
+	if options.Join != "" {
+		config.Meta.Peers = strings.Split(options.Join, ",")
+	}
+
+	// Validate the configuration.
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("%s. To generate a valid configuration file run `influxd config > influxdb.generated.conf`", err)
+	}
+	return config, nil
+}
+
+// Reload reads the config again the same way Run did and applies the
+// settings that can change without a restart to the running server.
+func (cmd *Command) Reload() error {
+	if cmd.Server == nil {
+		return errors.New("server not running")
+	}
+
+	config, err := cmd.loadConfig(cmd.options)
+	if err != nil {
+		return err
+	}
+	return cmd.Server.Reload(config)
+}
+
 // Close shuts down the server.
 func (cmd *Command) Close() error {
 	defer close(cmd.Closed)
@@ -164,6 +194,7 @@ func (cmd *Command) ParseFlags(args ...string) (Options, error) {
 	fs.StringVar(&options.Join, "join", "", "")
 	fs.StringVar(&options.CPUProfile, "cpuprofile", "", "")
 	fs.StringVar(&options.MemProfile, "memprofile", "", "")
+	fs.BoolVar(&options.StrictConfig, "strict-config", false, "")
 	fs.Usage = func() { fmt.Fprintln(cmd.Stderr, usage) }
 	if err := fs.Parse(args); err != nil {
 		return Options{}, err
@@ -196,6 +227,12 @@ func (cmd *Command) writePIDFile(path string) error {
 // ParseConfig parses the config at path.
 // Returns a demo configuration if path is blank.
 func (cmd *Command) ParseConfig(path string) (*Config, error) {
+	return cmd.parseConfig(path, false)
+}
+
+// parseConfig parses the config at path. If strict is true then keys that
+// do not match a config field are an error instead of being ignored.
+func (cmd *Command) parseConfig(path string, strict bool) (*Config, error) {
 	// Use demo configuration if no config path is specified.
 	if path == "" {
 		log.Println("no configuration provided, using default settings")
@@ -205,10 +242,17 @@ func (cmd *Command) ParseConfig(path string) (*Config, error) {
 	log.Printf("Using configuration at: %s\n", path)
 
 	config := NewConfig()
-	if _, err := toml.DecodeFile(path, &config); err != nil {
+	md, err := toml.DecodeFile(path, &config)
+	if err != nil {
 		return nil, err
 	}
 
+	if strict {
+		if err := checkUndecoded(md); err != nil {
+			return nil, err
+		}
+	}
+
 	return config, nil
 }
 
@@ -225,7 +269,8 @@ then a new cluster will be initialized unless the -join argument is used.
                           option will be overridden.
 
         -join <url>
-                          Joins the server to an existing cluster.
+                          Joins the server to an existing cluster. Defaults
+                          to the INFLUXDB_JOIN environment variable.
 
         -pidfile <path>
                           Write process ID to a file.
@@ -235,14 +280,19 @@ then a new cluster will be initialized unless the -join argument is used.
 
         -memprofile <path>
                           Write memory usage information to a file.
+
+        -strict-config
+                          Fail if the configuration file contains unknown
+                          settings, such as misspelled keys.
 `
 
 // Options represents the command line options that can be parsed.
 type Options struct {
-	ConfigPath string
-	PIDFile    string
-	Hostname   string
-	Join       string
-	CPUProfile string
-	MemProfile string
+	ConfigPath   string
+	PIDFile      string
+	Hostname     string
+	Join         string
+	CPUProfile   string
+	MemProfile   string
+	StrictConfig bool
 }
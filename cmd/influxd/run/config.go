@@ -3,6 +3,7 @@ package run
 import (
 	"errors"
 	"fmt"
+	"net"
 	"os"
 	"os/user"
 	"path/filepath"
@@ -11,6 +12,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/BurntSushi/toml"
 	"github.com/influxdb/influxdb/cluster"
 	"github.com/influxdb/influxdb/meta"
 	"github.com/influxdb/influxdb/monitor"
@@ -110,10 +112,28 @@ func (c *Config) Validate() error {
 		return errors.New("HintedHandoff.Dir must be specified")
 	}
 
+	if err := c.Meta.Validate(); err != nil {
+		return err
+	}
+
+	// The meta service always listens, so no enabled HTTP service may
+	// share its address.
+	if c.HTTPD.Enabled && bindConflict(c.Meta.BindAddress, c.HTTPD.BindAddress) {
+		return fmt.Errorf("HTTP.BindAddress %s conflicts with Meta.BindAddress %s", c.HTTPD.BindAddress, c.Meta.BindAddress)
+	} else if c.Admin.Enabled && bindConflict(c.Meta.BindAddress, c.Admin.BindAddress) {
+		return fmt.Errorf("Admin.BindAddress %s conflicts with Meta.BindAddress %s", c.Admin.BindAddress, c.Meta.BindAddress)
+	} else if c.HTTPD.Enabled && c.Admin.Enabled && bindConflict(c.HTTPD.BindAddress, c.Admin.BindAddress) {
+		return fmt.Errorf("Admin.BindAddress %s conflicts with HTTP.BindAddress %s", c.Admin.BindAddress, c.HTTPD.BindAddress)
+	}
+
 	if err := c.Data.Validate(); err != nil {
 		return err
 	}
 
+	if err := c.HTTPD.Validate(); err != nil {
+		return err
+	}
+
 	for _, g := range c.Graphites {
 		if err := g.Validate(); err != nil {
 			return fmt.Errorf("invalid graphite config: %v", err)
@@ -122,8 +142,50 @@ func (c *Config) Validate() error {
 	return nil
 }
 
+// checkUndecoded returns an error listing the keys in md that did not match
+// a config field.
+func checkUndecoded(md toml.MetaData) error {
+	keys := md.Undecoded()
+	if len(keys) == 0 {
+		return nil
+	}
+
+	names := make([]string, len(keys))
+	for i, k := range keys {
+		names[i] = k.String()
+	}
+	return fmt.Errorf("unknown config keys: %s", strings.Join(names, ", "))
+}
+
+// bindConflict returns true if listening on a and b would use the same port.
+// An empty or unspecified host listens on every interface and conflicts
+// with any host on the same port.
+func bindConflict(a, b string) bool {
+	ahost, aport, err := net.SplitHostPort(a)
+	if err != nil {
+		return false
+	}
+	bhost, bport, err := net.SplitHostPort(b)
+	if err != nil || aport != bport {
+		return false
+	}
+	return ahost == bhost || isAnyHost(ahost) || isAnyHost(bhost)
+}
+
+// isAnyHost returns true if host listens on every interface.
+func isAnyHost(host string) bool {
+	return host == "" || host == "0.0.0.0" || host == "::"
+}
+
 // ApplyEnvOverrides apply the environment configuration on top of the config.
+//
+// INFLUXDB_JOIN sets the comma-separated meta peers to join, like the -join
+// flag. The flag wins over the variable, and both are ignored once the node
+// has joined a cluster and saved its peers in the meta directory.
 func (c *Config) ApplyEnvOverrides() error {
+	if v := os.Getenv("INFLUXDB_JOIN"); v != "" {
+		c.Meta.Peers = strings.Split(v, ",")
+	}
 	return c.applyEnvOverrides("INFLUXDB", reflect.ValueOf(c))
 }
 
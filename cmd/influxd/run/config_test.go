@@ -2,6 +2,7 @@ package run_test
 
 import (
 	"os"
+	"reflect"
 	"testing"
 
 	"github.com/BurntSushi/toml"
@@ -145,3 +146,49 @@ enabled = true
 		t.Fatalf("unexpected graphite protocol(0): %s", c.Graphites[0].Protocol)
 	}
 }
+
+// Ensure the join peers can be set from the environment.
+func TestConfig_Parse_EnvOverride_Join(t *testing.T) {
+	c := run.NewConfig()
+	if err := os.Setenv("INFLUXDB_JOIN", "host0:8088,host1:8088"); err != nil {
+		t.Fatalf("failed to set env var: %v", err)
+	}
+	defer os.Unsetenv("INFLUXDB_JOIN")
+
+	if err := c.ApplyEnvOverrides(); err != nil {
+		t.Fatalf("failed to apply env overrides: %v", err)
+	}
+
+	if !reflect.DeepEqual(c.Meta.Peers, []string{"host0:8088", "host1:8088"}) {
+		t.Fatalf("unexpected meta peers: %v", c.Meta.Peers)
+	}
+}
+
+// Ensure the configuration rejects services that share a bind address.
+func TestConfig_Validate_BindConflict(t *testing.T) {
+	for i, tt := range []struct {
+		meta, http, admin string
+		valid             bool
+	}{
+		{meta: ":8088", http: ":8086", admin: ":8083", valid: true},
+		{meta: "127.0.0.1:8088", http: "127.0.0.2:8088", admin: ":8083", valid: true},
+		{meta: ":8088", http: ":8088", admin: ":8083"},
+		{meta: "127.0.0.1:8088", http: ":8088", admin: ":8083"},
+		{meta: ":8088", http: ":8086", admin: "0.0.0.0:8088"},
+		{meta: ":8088", http: ":8086", admin: ":8086"},
+	} {
+		c := run.NewConfig()
+		c.Meta.Dir = "/tmp/meta"
+		c.Data.Dir = "/tmp/data"
+		c.Data.WALDir = "/tmp/wal"
+		c.Meta.BindAddress = tt.meta
+		c.HTTPD.BindAddress = tt.http
+		c.Admin.Enabled = true
+		c.Admin.BindAddress = tt.admin
+		if err := c.Validate(); tt.valid && err != nil {
+			t.Errorf("%d. unexpected error: %s", i, err)
+		} else if !tt.valid && err == nil {
+			t.Errorf("%d. expected error", i)
+		}
+	}
+}
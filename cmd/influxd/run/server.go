@@ -1,6 +1,7 @@
 package run
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net"
@@ -50,9 +51,11 @@ type Server struct {
 	err     chan error
 	closing chan struct{}
 
-	Hostname    string
-	BindAddress string
-	Listener    net.Listener
+	Hostname     string
+	BindAddress  string
+	BindNetwork  string
+	TCPKeepAlive time.Duration
+	Listener     net.Listener
 
 	MetaStore     *meta.Store
 	TSDBStore     *tsdb.Store
@@ -91,8 +94,10 @@ func NewServer(c *Config, buildInfo *BuildInfo) (*Server, error) {
 		err:       make(chan error),
 		closing:   make(chan struct{}),
 
-		Hostname:    c.Meta.Hostname,
-		BindAddress: c.Meta.BindAddress,
+		Hostname:     c.Meta.Hostname,
+		BindAddress:  c.Meta.BindAddress,
+		BindNetwork:  c.Meta.BindNetwork,
+		TCPKeepAlive: time.Duration(c.Meta.TCPKeepAlive),
 
 		MetaStore: meta.NewStore(c.Meta),
 		TSDBStore: tsdbStore,
@@ -328,8 +333,13 @@ func (s *Server) Open() error {
 			return err
 		}
 
+		network := s.BindNetwork
+		if network == "" {
+			network = meta.DefaultBindNetwork
+		}
+
 		hostport := net.JoinHostPort(host, port)
-		addr, err := net.ResolveTCPAddr("tcp", hostport)
+		addr, err := net.ResolveTCPAddr(network, hostport)
 		if err != nil {
 			return fmt.Errorf("resolve tcp: addr=%s, err=%s", hostport, err)
 		}
@@ -337,11 +347,11 @@ func (s *Server) Open() error {
 		s.MetaStore.RemoteAddr = &tcpaddr{hostport}
 
 		// Open shared TCP connection.
-		ln, err := net.Listen("tcp", s.BindAddress)
+		ln, err := net.Listen(network, s.BindAddress)
 		if err != nil {
 			return fmt.Errorf("listen: %s", err)
 		}
-		s.Listener = ln
+		s.Listener = tcp.NewKeepAliveListener(ln, s.TCPKeepAlive)
 
 		// The port 0 is used, we need to retrieve the port assigned by the kernel
 		if strings.HasSuffix(s.BindAddress, ":0") {
@@ -358,10 +368,20 @@ func (s *Server) Open() error {
 		s.ClusterService.Listener = mux.Listen(cluster.MuxHeader)
 		s.SnapshotterService.Listener = mux.Listen(snapshotter.MuxHeader)
 		s.CopierService.Listener = mux.Listen(copier.MuxHeader)
-		go mux.Serve(ln)
-
-		// Open meta store.
-		if err := s.MetaStore.Open(); err != nil {
+		go mux.Serve(s.Listener)
+
+		// Open meta store. Opening is aborted if the server is closed while
+		// waiting for a leader to be elected.
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			select {
+			case <-s.closing:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		if err := s.MetaStore.OpenContext(ctx); err != nil {
 			return fmt.Errorf("open meta store: %s", err)
 		}
 		go s.monitorErrorChan(s.MetaStore.Err())
@@ -460,6 +480,46 @@ func (s *Server) Close() error {
 	return nil
 }
 
+// ReloadTLS reloads the certificates of services that serve TLS. Every
+// service is reloaded even if an earlier one fails; the first error is returned.
+func (s *Server) ReloadTLS() error {
+	var err error
+	for _, service := range s.Services {
+		r, ok := service.(interface {
+			ReloadTLS() error
+		})
+		if !ok {
+			continue
+		}
+		if e := r.ReloadTLS(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// Reload applies the settings in c that can change without a restart: the
+// meta store's log level and timeouts, the HTTP service's request logging,
+// and TLS certificates. Every part is reloaded even if an earlier one fails;
+// the first error is returned.
+func (s *Server) Reload(c *Config) error {
+	var err error
+	if s.MetaStore != nil {
+		err = s.MetaStore.Reload(c.Meta)
+	}
+	for _, service := range s.Services {
+		if h, ok := service.(*httpd.Service); ok {
+			if e := h.Reload(c.HTTPD); e != nil && err == nil {
+				err = e
+			}
+		}
+	}
+	if e := s.ReloadTLS(); e != nil && err == nil {
+		err = e
+	}
+	return err
+}
+
 // startServerReporting starts periodic server reporting.
 func (s *Server) startServerReporting() {
 	for {
@@ -0,0 +1,98 @@
+package meta
+
+import (
+	"container/list"
+	"time"
+)
+
+type authUser struct {
+	salt    []byte
+	hash    []byte
+	expires time.Time // zero if the entry does not expire
+}
+
+// expired returns true if the entry has expired at now.
+func (au authUser) expired(now time.Time) bool {
+	return !au.expires.IsZero() && !now.Before(au.expires)
+}
+
+// authCache is a least-recently-used cache of successful authentications
+// keyed by username. It is not safe for concurrent use.
+type authCache struct {
+	maxEntries int // zero means no limit
+	ll         *list.List
+	entries    map[string]*list.Element
+
+	// Counters are monotonic from the time the cache is created.
+	hits      uint64 // lookups that used a cached entry
+	misses    uint64 // lookups that found no valid entry
+	evictions uint64 // entries removed for size or expiry
+}
+
+type authCacheEntry struct {
+	username string
+	user     authUser
+}
+
+// newAuthCache returns a cache that holds at most maxEntries users.
+// If maxEntries is zero then the cache is unbounded.
+func newAuthCache(maxEntries int) *authCache {
+	return &authCache{
+		maxEntries: maxEntries,
+		ll:         list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+}
+
+// get returns the cached entry for username and marks it as recently used.
+func (c *authCache) get(username string) (authUser, bool) {
+	e, ok := c.entries[username]
+	if !ok {
+		return authUser{}, false
+	}
+	c.ll.MoveToFront(e)
+	return e.Value.(*authCacheEntry).user, true
+}
+
+// set adds or replaces the entry for username. The least recently used
+// entry is evicted if the cache is full.
+func (c *authCache) set(username string, au authUser) {
+	if e, ok := c.entries[username]; ok {
+		c.ll.MoveToFront(e)
+		e.Value.(*authCacheEntry).user = au
+		return
+	}
+
+	c.entries[username] = c.ll.PushFront(&authCacheEntry{username: username, user: au})
+	if c.maxEntries > 0 && c.ll.Len() > c.maxEntries {
+		c.removeElement(c.ll.Back())
+		c.evictions++
+	}
+}
+
+// remove removes the entry for username, if it exists.
+func (c *authCache) remove(username string) {
+	if e, ok := c.entries[username]; ok {
+		c.removeElement(e)
+	}
+}
+
+// removeExpired removes all entries that have expired at now.
+func (c *authCache) removeExpired(now time.Time) {
+	for e := c.ll.Front(); e != nil; {
+		next := e.Next()
+		if e.Value.(*authCacheEntry).user.expired(now) {
+			c.removeElement(e)
+			c.evictions++
+		}
+		e = next
+	}
+}
+
+// len returns the number of cached entries.
+func (c *authCache) len() int { return c.ll.Len() }
+
+func (c *authCache) removeElement(e *list.Element) {
+	c.ll.Remove(e)
+	delete(c.entries, e.Value.(*authCacheEntry).username)
+}
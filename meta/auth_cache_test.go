@@ -0,0 +1,74 @@
+package meta
+
+import (
+	"testing"
+	"time"
+)
+
+// Ensure the least recently used entry is evicted when the cache is full.
+func TestAuthCache_Evict(t *testing.T) {
+	c := newAuthCache(2)
+	c.set("a", authUser{})
+	c.set("b", authUser{})
+
+	// Use "a" so that "b" is the least recently used.
+	if _, ok := c.get("a"); !ok {
+		t.Fatal("expected a")
+	}
+
+	c.set("c", authUser{})
+	if c.len() != 2 {
+		t.Fatalf("unexpected len: %d", c.len())
+	} else if c.evictions != 1 {
+		t.Fatalf("unexpected evictions: %d", c.evictions)
+	} else if _, ok := c.get("b"); ok {
+		t.Fatal("expected b to be evicted")
+	} else if _, ok := c.get("a"); !ok {
+		t.Fatal("expected a to be cached")
+	} else if _, ok := c.get("c"); !ok {
+		t.Fatal("expected c to be cached")
+	}
+}
+
+// Ensure a cache with no limit never evicts.
+func TestAuthCache_Unbounded(t *testing.T) {
+	c := newAuthCache(0)
+	for _, name := range []string{"a", "b", "c", "d"} {
+		c.set(name, authUser{})
+	}
+	if c.len() != 4 {
+		t.Fatalf("unexpected len: %d", c.len())
+	}
+}
+
+// Ensure replacing an entry does not evict other entries.
+func TestAuthCache_Replace(t *testing.T) {
+	c := newAuthCache(2)
+	c.set("a", authUser{hash: []byte("1")})
+	c.set("b", authUser{})
+	c.set("a", authUser{hash: []byte("2")})
+
+	if c.len() != 2 {
+		t.Fatalf("unexpected len: %d", c.len())
+	} else if au, _ := c.get("a"); string(au.hash) != "2" {
+		t.Fatalf("unexpected hash: %s", au.hash)
+	}
+}
+
+// Ensure expired entries are removed.
+func TestAuthCache_RemoveExpired(t *testing.T) {
+	now := time.Unix(100, 0)
+	c := newAuthCache(0)
+	c.set("a", authUser{expires: now})
+	c.set("b", authUser{expires: now.Add(time.Second)})
+	c.set("c", authUser{})
+
+	c.removeExpired(now)
+	if _, ok := c.get("a"); ok {
+		t.Fatal("expected a to be removed")
+	} else if _, ok := c.get("b"); !ok {
+		t.Fatal("expected b to be cached")
+	} else if _, ok := c.get("c"); !ok {
+		t.Fatal("expected c to be cached")
+	}
+}
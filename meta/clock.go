@@ -0,0 +1,42 @@
+package meta
+
+import "time"
+
+// clock abstracts the passage of time so the store's timeouts and polling
+// loops can be driven deterministically in tests.
+type clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) timer
+	NewTicker(d time.Duration) ticker
+}
+
+// timer represents a single event, such as a time.Timer.
+type timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+// ticker represents a recurring event, such as a time.Ticker.
+type ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// realClock implements clock using the time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTimer(d time.Duration) timer { return realTimer{time.NewTimer(d)} }
+
+func (realClock) NewTicker(d time.Duration) ticker { return realTicker{time.NewTicker(d)} }
+
+type realTimer struct{ t *time.Timer }
+
+func (t realTimer) C() <-chan time.Time { return t.t.C }
+func (t realTimer) Stop() bool          { return t.t.Stop() }
+
+type realTicker struct{ t *time.Ticker }
+
+func (t realTicker) C() <-chan time.Time { return t.t.C }
+func (t realTicker) Stop()               { t.t.Stop() }
@@ -1,9 +1,13 @@
 package meta
 
 import (
+	"errors"
+	"fmt"
+	"reflect"
 	"time"
 
 	"github.com/influxdb/influxdb/toml"
+	"golang.org/x/crypto/bcrypt"
 )
 
 const (
@@ -13,6 +17,9 @@ const (
 	// DefaultBindAddress is the default address to bind to.
 	DefaultBindAddress = ":8088"
 
+	// DefaultBindNetwork is the default network to bind to.
+	DefaultBindNetwork = "tcp"
+
 	// DefaultHeartbeatTimeout is the default heartbeat timeout for the store.
 	DefaultHeartbeatTimeout = 1000 * time.Millisecond
 
@@ -25,40 +32,391 @@ const (
 	// DefaultCommitTimeout is the default commit timeout for the store.
 	DefaultCommitTimeout = 50 * time.Millisecond
 
+	// DefaultRaftSnapshotInterval is the default time between checks for
+	// whether to snapshot the raft log.
+	DefaultRaftSnapshotInterval = 120 * time.Second
+
+	// DefaultRaftSnapshotThreshold is the default number of new raft log
+	// entries required to take a snapshot.
+	DefaultRaftSnapshotThreshold = 8192
+
+	// DefaultRaftTransportTimeout is the default time limit for sending a
+	// message to another raft peer.
+	DefaultRaftTransportTimeout = 10 * time.Second
+
+	// DefaultRaftMaxPool is the default number of idle connections kept
+	// open to each raft peer.
+	DefaultRaftMaxPool = 3
+
 	// DefaultRaftPromotionEnabled is the default for auto promoting a node to a raft node when needed
 	DefaultRaftPromotionEnabled = true
 
 	// DefaultLoggingEnabled determines if log messages are printed for the meta service
 	DefaultLoggingEnabled = true
+
+	// DefaultLogLevel is the default minimum level of meta log messages.
+	DefaultLogLevel = "info"
+
+	// DefaultAuthCacheTTL is the default amount of time a successful authentication is cached.
+	DefaultAuthCacheTTL = 10 * time.Minute
+
+	// DefaultHashCost is the default bcrypt cost used to hash user passwords.
+	DefaultHashCost = bcrypt.DefaultCost
+
+	// DefaultJoinRetryInterval is the default delay before retrying to join a cluster.
+	DefaultJoinRetryInterval = time.Second
+
+	// DefaultJoinRetryMaxInterval is the default limit on the delay between join retries.
+	DefaultJoinRetryMaxInterval = 30 * time.Second
+
+	// DefaultApplyTimeout is the default time limit for committing a command.
+	DefaultApplyTimeout = 10 * time.Second
+
+	// DefaultRPCTimeout is the default time limit for a cluster RPC call.
+	DefaultRPCTimeout = 30 * time.Second
+
+	// DefaultSnapshotCompression is the default compression of meta data
+	// sent to other nodes.
+	DefaultSnapshotCompression = SnapshotCompressionNone
+
+	// DefaultRaftStoreType is the default storage of the raft log.
+	DefaultRaftStoreType = RaftStoreBolt
+
+	// DefaultSnapshotQueueTimeout is the default time a request for the meta
+	// data waits for a free slot when max-concurrent-snapshots is reached.
+	DefaultSnapshotQueueTimeout = 10 * time.Second
+
+	// DefaultDataDirMode is the default permission of the data directory.
+	DefaultDataDirMode = 0700
+
+	// DefaultTCPKeepAlive is the default keep-alive period of connections
+	// accepted on the bind address.
+	DefaultTCPKeepAlive = 15 * time.Second
+)
+
+// DefaultApplyLatencyBuckets are the default upper bounds of the buckets
+// of the apply latency histogram.
+var DefaultApplyLatencyBuckets = []time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+}
+
+// Snapshot compression settings.
+const (
+	SnapshotCompressionNone = "none"
+	SnapshotCompressionGzip = "gzip"
+)
+
+// Raft store types. The in-memory store keeps the raft log in memory and
+// discards snapshots, so its state is lost when the store closes. It is
+// meant for tests.
+const (
+	RaftStoreBolt  = "bolt"
+	RaftStoreInmem = "inmem"
 )
 
 // Config represents the meta configuration.
 type Config struct {
-	Dir                  string        `toml:"dir"`
-	Hostname             string        `toml:"hostname"`
-	BindAddress          string        `toml:"bind-address"`
-	Peers                []string      `toml:"-"`
-	RetentionAutoCreate  bool          `toml:"retention-autocreate"`
-	ElectionTimeout      toml.Duration `toml:"election-timeout"`
-	HeartbeatTimeout     toml.Duration `toml:"heartbeat-timeout"`
-	LeaderLeaseTimeout   toml.Duration `toml:"leader-lease-timeout"`
-	CommitTimeout        toml.Duration `toml:"commit-timeout"`
-	ClusterTracing       bool          `toml:"cluster-tracing"`
-	RaftPromotionEnabled bool          `toml:"raft-promotion-enabled"`
-	LoggingEnabled       bool          `toml:"logging-enabled"`
+	Dir                   string        `toml:"dir"`
+	Hostname              string        `toml:"hostname"`
+	BindAddress           string        `toml:"bind-address"`
+	BindNetwork           string        `toml:"bind-network"`
+	Peers                 []string      `toml:"-"`
+	RetentionAutoCreate   bool          `toml:"retention-autocreate"`
+	ElectionTimeout       toml.Duration `toml:"election-timeout"`
+	HeartbeatTimeout      toml.Duration `toml:"heartbeat-timeout"`
+	LeaderLeaseTimeout    toml.Duration `toml:"leader-lease-timeout"`
+	CommitTimeout         toml.Duration `toml:"commit-timeout"`
+	RaftSnapshotInterval  toml.Duration `toml:"raft-snapshot-interval"`
+	RaftSnapshotThreshold int           `toml:"raft-snapshot-threshold"`
+	RaftTransportTimeout  toml.Duration `toml:"raft-transport-timeout"`
+	RaftMaxPool           int           `toml:"raft-max-pool"`
+	RaftStoreType         string        `toml:"raft-store-type"`
+	ClusterTracing        bool          `toml:"cluster-tracing"`
+	RaftPromotionEnabled  bool          `toml:"raft-promotion-enabled"`
+	LoggingEnabled        bool          `toml:"logging-enabled"`
+	LogLevel              string        `toml:"log-level"`
+	AuthCacheTTL          toml.Duration `toml:"auth-cache-ttl"`
+	AuthCacheMaxEntries   int           `toml:"auth-cache-max-entries"`
+	HashCost              int           `toml:"hash-cost"`
+	SnapshotCompression   string        `toml:"snapshot-compression"`
+	JoinRetryInterval     toml.Duration `toml:"join-retry-interval"`
+	JoinRetryMaxInterval  toml.Duration `toml:"join-retry-max-interval"`
+	JoinRetryTimeout      toml.Duration `toml:"join-retry-timeout"`
+	JoinTimeout           toml.Duration `toml:"join-timeout"`
+	RPCTimeout            toml.Duration `toml:"rpc-timeout"`
+	ApplyTimeout          toml.Duration `toml:"apply-timeout"`
+	DataDirMode           toml.FileMode `toml:"data-dir-mode"`
+
+	// MaxConcurrentSnapshots limits how many requests for the meta data from
+	// other nodes are encoded at once, and zero is unlimited. Excess requests
+	// wait up to SnapshotQueueTimeout for a slot and then fail. A zero
+	// timeout waits forever.
+	MaxConcurrentSnapshots int           `toml:"max-concurrent-snapshots"`
+	SnapshotQueueTimeout   toml.Duration `toml:"snapshot-queue-timeout"`
+
+	// SnapshotChecksum prefixes raft snapshots written by this node with a
+	// checksum. Older releases can't restore such snapshots, including ones
+	// the leader sends them, so it must only be enabled once every node has
+	// been upgraded. Snapshots are read with or without one.
+	SnapshotChecksum bool `toml:"snapshot-checksum"`
+
+	// BatchCommands allows Store.ApplyBatch. Older releases can't apply a
+	// batch log entry and stop when they replicate one, so it must only be
+	// enabled once every node has been upgraded.
+	BatchCommands bool `toml:"batch-commands"`
+
+	// ApplyLatencyBuckets are the upper bounds of the buckets of the apply
+	// latency histogram, in increasing order. Empty uses the defaults.
+	ApplyLatencyBuckets []toml.Duration `toml:"apply-latency-buckets"`
+
+	// TCPKeepAlive is the keep-alive period of connections accepted on the
+	// bind address. Zero disables keep-alive.
+	TCPKeepAlive toml.Duration `toml:"tcp-keep-alive"`
+
+	// LeaderWatchdog is how long the store may be without a raft leader
+	// before it reports an error. Zero disables the watchdog.
+	LeaderWatchdog toml.Duration `toml:"leader-watchdog"`
 }
 
 // NewConfig builds a new configuration with default values.
 func NewConfig() *Config {
 	return &Config{
-		Hostname:             DefaultHostname,
-		BindAddress:          DefaultBindAddress,
-		RetentionAutoCreate:  true,
-		ElectionTimeout:      toml.Duration(DefaultElectionTimeout),
-		HeartbeatTimeout:     toml.Duration(DefaultHeartbeatTimeout),
-		LeaderLeaseTimeout:   toml.Duration(DefaultLeaderLeaseTimeout),
-		CommitTimeout:        toml.Duration(DefaultCommitTimeout),
-		RaftPromotionEnabled: DefaultRaftPromotionEnabled,
-		LoggingEnabled:       DefaultLoggingEnabled,
+		Hostname:              DefaultHostname,
+		BindAddress:           DefaultBindAddress,
+		BindNetwork:           DefaultBindNetwork,
+		RetentionAutoCreate:   true,
+		ElectionTimeout:       toml.Duration(DefaultElectionTimeout),
+		HeartbeatTimeout:      toml.Duration(DefaultHeartbeatTimeout),
+		LeaderLeaseTimeout:    toml.Duration(DefaultLeaderLeaseTimeout),
+		CommitTimeout:         toml.Duration(DefaultCommitTimeout),
+		RaftSnapshotInterval:  toml.Duration(DefaultRaftSnapshotInterval),
+		RaftSnapshotThreshold: DefaultRaftSnapshotThreshold,
+		RaftTransportTimeout:  toml.Duration(DefaultRaftTransportTimeout),
+		RaftMaxPool:           DefaultRaftMaxPool,
+		RaftStoreType:         DefaultRaftStoreType,
+		RaftPromotionEnabled:  DefaultRaftPromotionEnabled,
+		LoggingEnabled:        DefaultLoggingEnabled,
+		LogLevel:              DefaultLogLevel,
+		AuthCacheTTL:          toml.Duration(DefaultAuthCacheTTL),
+		HashCost:              DefaultHashCost,
+		SnapshotCompression:   DefaultSnapshotCompression,
+		DataDirMode:           DefaultDataDirMode,
+		TCPKeepAlive:          toml.Duration(DefaultTCPKeepAlive),
+		JoinRetryInterval:     toml.Duration(DefaultJoinRetryInterval),
+		JoinRetryMaxInterval:  toml.Duration(DefaultJoinRetryMaxInterval),
+		RPCTimeout:            toml.Duration(DefaultRPCTimeout),
+		ApplyTimeout:          toml.Duration(DefaultApplyTimeout),
+		SnapshotQueueTimeout:  toml.Duration(DefaultSnapshotQueueTimeout),
+	}
+}
+
+// ApplyDefaults sets fields whose zero value is not usable to their
+// defaults. Fields where zero disables a limit, such as AuthCacheTTL or
+// RPCTimeout, and boolean fields are left alone. It is safe to call more
+// than once.
+func (c *Config) ApplyDefaults() {
+	if c.Hostname == "" {
+		c.Hostname = DefaultHostname
+	}
+	if c.BindAddress == "" {
+		c.BindAddress = DefaultBindAddress
+	}
+	if c.BindNetwork == "" {
+		c.BindNetwork = DefaultBindNetwork
+	}
+	if c.ElectionTimeout == 0 {
+		c.ElectionTimeout = toml.Duration(DefaultElectionTimeout)
+	}
+	if c.HeartbeatTimeout == 0 {
+		c.HeartbeatTimeout = toml.Duration(DefaultHeartbeatTimeout)
+	}
+	if c.LeaderLeaseTimeout == 0 {
+		c.LeaderLeaseTimeout = toml.Duration(DefaultLeaderLeaseTimeout)
+	}
+	if c.CommitTimeout == 0 {
+		c.CommitTimeout = toml.Duration(DefaultCommitTimeout)
+	}
+	if c.RaftSnapshotInterval == 0 {
+		c.RaftSnapshotInterval = toml.Duration(DefaultRaftSnapshotInterval)
+	}
+	if c.RaftSnapshotThreshold == 0 {
+		c.RaftSnapshotThreshold = DefaultRaftSnapshotThreshold
+	}
+	if c.RaftTransportTimeout == 0 {
+		c.RaftTransportTimeout = toml.Duration(DefaultRaftTransportTimeout)
+	}
+	if c.RaftMaxPool == 0 {
+		c.RaftMaxPool = DefaultRaftMaxPool
+	}
+	if c.RaftStoreType == "" {
+		c.RaftStoreType = DefaultRaftStoreType
+	}
+	if c.LogLevel == "" {
+		c.LogLevel = DefaultLogLevel
+	}
+	if c.HashCost == 0 {
+		c.HashCost = DefaultHashCost
+	}
+	if c.SnapshotCompression == "" {
+		c.SnapshotCompression = DefaultSnapshotCompression
+	}
+	if c.JoinRetryInterval == 0 {
+		c.JoinRetryInterval = toml.Duration(DefaultJoinRetryInterval)
+	}
+	if c.JoinRetryMaxInterval == 0 {
+		c.JoinRetryMaxInterval = toml.Duration(DefaultJoinRetryMaxInterval)
+	}
+	if c.DataDirMode == 0 {
+		c.DataDirMode = DefaultDataDirMode
+	}
+}
+
+// Validate returns an error if the config is invalid.
+func (c *Config) Validate() error {
+	// Raft rejects timeouts below these limits when the store opens.
+	if time.Duration(c.HeartbeatTimeout) < 5*time.Millisecond {
+		return errors.New("Meta.HeartbeatTimeout must be at least 5ms")
+	} else if time.Duration(c.ElectionTimeout) < time.Duration(c.HeartbeatTimeout) {
+		return errors.New("Meta.ElectionTimeout must be at least Meta.HeartbeatTimeout")
+	} else if time.Duration(c.LeaderLeaseTimeout) < 5*time.Millisecond {
+		return errors.New("Meta.LeaderLeaseTimeout must be at least 5ms")
+	} else if c.LeaderLeaseTimeout > c.HeartbeatTimeout {
+		return errors.New("Meta.LeaderLeaseTimeout must not be greater than Meta.HeartbeatTimeout")
+	} else if time.Duration(c.CommitTimeout) < time.Millisecond {
+		return errors.New("Meta.CommitTimeout must be at least 1ms")
+	} else if time.Duration(c.RaftSnapshotInterval) < 5*time.Millisecond {
+		return errors.New("Meta.RaftSnapshotInterval must be at least 5ms")
+	} else if c.RaftSnapshotThreshold <= 0 {
+		return errors.New("Meta.RaftSnapshotThreshold must be positive")
+	} else if c.RaftTransportTimeout <= 0 {
+		return errors.New("Meta.RaftTransportTimeout must be positive")
+	} else if c.RaftMaxPool <= 0 {
+		return errors.New("Meta.RaftMaxPool must be positive")
+	}
+
+	// Zero disables these limits, so only negative values are invalid.
+	if c.AuthCacheTTL < 0 {
+		return errors.New("Meta.AuthCacheTTL must not be negative")
+	} else if c.AuthCacheMaxEntries < 0 {
+		return errors.New("Meta.AuthCacheMaxEntries must not be negative")
+	} else if c.JoinRetryInterval < 0 {
+		return errors.New("Meta.JoinRetryInterval must not be negative")
+	} else if c.JoinRetryMaxInterval < 0 {
+		return errors.New("Meta.JoinRetryMaxInterval must not be negative")
+	} else if c.JoinRetryTimeout < 0 {
+		return errors.New("Meta.JoinRetryTimeout must not be negative")
+	} else if c.JoinTimeout < 0 {
+		return errors.New("Meta.JoinTimeout must not be negative")
+	} else if c.RPCTimeout < 0 {
+		return errors.New("Meta.RPCTimeout must not be negative")
+	} else if c.ApplyTimeout < 0 {
+		return errors.New("Meta.ApplyTimeout must not be negative")
+	} else if c.TCPKeepAlive < 0 {
+		return errors.New("Meta.TCPKeepAlive must not be negative")
+	} else if c.LeaderWatchdog < 0 {
+		return errors.New("Meta.LeaderWatchdog must not be negative")
+	} else if c.MaxConcurrentSnapshots < 0 {
+		return errors.New("Meta.MaxConcurrentSnapshots must not be negative")
+	} else if c.SnapshotQueueTimeout < 0 {
+		return errors.New("Meta.SnapshotQueueTimeout must not be negative")
+	}
+
+	for i, d := range c.ApplyLatencyBuckets {
+		if d <= 0 {
+			return errors.New("Meta.ApplyLatencyBuckets must be positive")
+		} else if i > 0 && d <= c.ApplyLatencyBuckets[i-1] {
+			return errors.New("Meta.ApplyLatencyBuckets must be in increasing order")
+		}
+	}
+
+	if c.HashCost < bcrypt.MinCost || c.HashCost > bcrypt.MaxCost {
+		return fmt.Errorf("Meta.HashCost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	}
+
+	switch c.BindNetwork {
+	case "", "tcp", "tcp4", "tcp6":
+	default:
+		return fmt.Errorf("Meta.BindNetwork must be one of tcp, tcp4 or tcp6, got %s", c.BindNetwork)
+	}
+
+	if _, err := parseLogLevel(c.LogLevel); err != nil {
+		return err
+	}
+
+	switch c.SnapshotCompression {
+	case "", SnapshotCompressionNone, SnapshotCompressionGzip:
+	default:
+		return fmt.Errorf("unrecognized snapshot compression %s", c.SnapshotCompression)
+	}
+
+	switch c.RaftStoreType {
+	case "", RaftStoreBolt, RaftStoreInmem:
+	default:
+		return fmt.Errorf("unrecognized raft store type %s", c.RaftStoreType)
+	}
+
+	// Only permission bits are allowed and the owner must be able to use the
+	// directory. Zero is replaced by the default.
+	if c.DataDirMode != 0 && (c.DataDirMode&^0777 != 0 || c.DataDirMode&0700 != 0700) {
+		return fmt.Errorf("Meta.DataDirMode must be a permission between 0700 and 0777, got %04o", uint32(c.DataDirMode))
+	}
+	return nil
+}
+
+// reloadableFields names the Config fields that Store.Reload can change.
+var reloadableFields = map[string]bool{
+	"LogLevel":     true,
+	"ApplyTimeout": true,
+	"RPCTimeout":   true,
+}
+
+// checkReload returns an error naming the first field, other than those
+// Store.Reload can change, that differs between prev and next. Fields not
+// read from the config file, such as Peers, are ignored.
+func checkReload(prev, next *Config) error {
+	pv, nv := reflect.ValueOf(prev).Elem(), reflect.ValueOf(next).Elem()
+	t := pv.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if reloadableFields[f.Name] || f.Tag.Get("toml") == "-" {
+			continue
+		}
+		if !reflect.DeepEqual(pv.Field(i).Interface(), nv.Field(i).Interface()) {
+			return fmt.Errorf("Meta.%s cannot be changed without a restart", f.Name)
+		}
+	}
+	return nil
+}
+
+// logLevel is the minimum severity of messages the store writes to its logger.
+type logLevel int
+
+const (
+	logLevelDebug logLevel = iota
+	logLevelInfo
+	logLevelWarn
+	logLevelError
+)
+
+// parseLogLevel returns the level named by s. An empty string is info, as
+// is the level returned with an error.
+func parseLogLevel(s string) (logLevel, error) {
+	switch s {
+	case "debug":
+		return logLevelDebug, nil
+	case "", "info":
+		return logLevelInfo, nil
+	case "warn":
+		return logLevelWarn, nil
+	case "error":
+		return logLevelError, nil
 	}
+	return logLevelInfo, fmt.Errorf("Meta.LogLevel must be one of debug, info, warn or error, got %s", s)
 }
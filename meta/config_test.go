@@ -1,11 +1,15 @@
 package meta_test
 
 import (
+	"bytes"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/influxdb/influxdb/meta"
+	itoml "github.com/influxdb/influxdb/toml"
 )
 
 func TestConfig_Parse(t *testing.T) {
@@ -13,12 +17,37 @@ func TestConfig_Parse(t *testing.T) {
 	var c meta.Config
 	if _, err := toml.Decode(`
 dir = "/tmp/foo"
+bind-network = "tcp6"
 election-timeout = "10s"
 heartbeat-timeout = "20s"
 leader-lease-timeout = "30h"
 commit-timeout = "40m"
+raft-snapshot-interval = "1m"
+raft-snapshot-threshold = 1024
+raft-transport-timeout = "20s"
+raft-max-pool = 5
+raft-store-type = "inmem"
 raft-promotion-enabled = false
 logging-enabled = false
+log-level = "warn"
+auth-cache-ttl = "5m"
+auth-cache-max-entries = 100
+hash-cost = 12
+snapshot-compression = "gzip"
+join-retry-interval = "2s"
+join-retry-max-interval = "1m"
+join-retry-timeout = "5m"
+join-timeout = "10m"
+rpc-timeout = "15s"
+apply-timeout = "5s"
+data-dir-mode = "0750"
+tcp-keep-alive = "1m"
+leader-watchdog = "10m"
+apply-latency-buckets = ["10ms", "1s"]
+max-concurrent-snapshots = 4
+snapshot-queue-timeout = "3s"
+snapshot-checksum = true
+batch-commands = true
 `, &c); err != nil {
 		t.Fatal(err)
 	}
@@ -26,6 +55,8 @@ logging-enabled = false
 	// Validate configuration.
 	if c.Dir != "/tmp/foo" {
 		t.Fatalf("unexpected dir: %s", c.Dir)
+	} else if c.BindNetwork != "tcp6" {
+		t.Fatalf("unexpected bind network: %s", c.BindNetwork)
 	} else if time.Duration(c.ElectionTimeout) != 10*time.Second {
 		t.Fatalf("unexpected election timeout: %v", c.ElectionTimeout)
 	} else if time.Duration(c.HeartbeatTimeout) != 20*time.Second {
@@ -34,9 +65,243 @@ logging-enabled = false
 		t.Fatalf("unexpected leader lease timeout: %v", c.LeaderLeaseTimeout)
 	} else if time.Duration(c.CommitTimeout) != 40*time.Minute {
 		t.Fatalf("unexpected commit timeout: %v", c.CommitTimeout)
+	} else if time.Duration(c.RaftSnapshotInterval) != time.Minute {
+		t.Fatalf("unexpected raft snapshot interval: %v", c.RaftSnapshotInterval)
+	} else if c.RaftSnapshotThreshold != 1024 {
+		t.Fatalf("unexpected raft snapshot threshold: %v", c.RaftSnapshotThreshold)
+	} else if time.Duration(c.RaftTransportTimeout) != 20*time.Second {
+		t.Fatalf("unexpected raft transport timeout: %v", c.RaftTransportTimeout)
+	} else if c.RaftMaxPool != 5 {
+		t.Fatalf("unexpected raft max pool: %v", c.RaftMaxPool)
+	} else if c.RaftStoreType != meta.RaftStoreInmem {
+		t.Fatalf("unexpected raft store type: %v", c.RaftStoreType)
 	} else if c.RaftPromotionEnabled {
 		t.Fatalf("unexpected raft promotion enabled: %v", c.RaftPromotionEnabled)
 	} else if c.LoggingEnabled {
 		t.Fatalf("unexpected logging enabled: %v", c.LoggingEnabled)
+	} else if c.LogLevel != "warn" {
+		t.Fatalf("unexpected log level: %s", c.LogLevel)
+	} else if time.Duration(c.AuthCacheTTL) != 5*time.Minute {
+		t.Fatalf("unexpected auth cache ttl: %v", c.AuthCacheTTL)
+	} else if c.AuthCacheMaxEntries != 100 {
+		t.Fatalf("unexpected auth cache max entries: %v", c.AuthCacheMaxEntries)
+	} else if c.HashCost != 12 {
+		t.Fatalf("unexpected hash cost: %v", c.HashCost)
+	} else if c.SnapshotCompression != "gzip" {
+		t.Fatalf("unexpected snapshot compression: %v", c.SnapshotCompression)
+	} else if time.Duration(c.JoinRetryInterval) != 2*time.Second {
+		t.Fatalf("unexpected join retry interval: %v", c.JoinRetryInterval)
+	} else if time.Duration(c.JoinRetryMaxInterval) != time.Minute {
+		t.Fatalf("unexpected join retry max interval: %v", c.JoinRetryMaxInterval)
+	} else if time.Duration(c.JoinRetryTimeout) != 5*time.Minute {
+		t.Fatalf("unexpected join retry timeout: %v", c.JoinRetryTimeout)
+	} else if time.Duration(c.JoinTimeout) != 10*time.Minute {
+		t.Fatalf("unexpected join timeout: %v", c.JoinTimeout)
+	} else if time.Duration(c.RPCTimeout) != 15*time.Second {
+		t.Fatalf("unexpected rpc timeout: %v", c.RPCTimeout)
+	} else if time.Duration(c.ApplyTimeout) != 5*time.Second {
+		t.Fatalf("unexpected apply timeout: %v", c.ApplyTimeout)
+	} else if c.DataDirMode != 0750 {
+		t.Fatalf("unexpected data dir mode: %o", c.DataDirMode)
+	} else if time.Duration(c.TCPKeepAlive) != time.Minute {
+		t.Fatalf("unexpected tcp keep alive: %v", c.TCPKeepAlive)
+	} else if time.Duration(c.LeaderWatchdog) != 10*time.Minute {
+		t.Fatalf("unexpected leader watchdog: %v", c.LeaderWatchdog)
+	} else if !reflect.DeepEqual(c.ApplyLatencyBuckets, []itoml.Duration{itoml.Duration(10 * time.Millisecond), itoml.Duration(time.Second)}) {
+		t.Fatalf("unexpected apply latency buckets: %v", c.ApplyLatencyBuckets)
+	} else if c.MaxConcurrentSnapshots != 4 {
+		t.Fatalf("unexpected max concurrent snapshots: %d", c.MaxConcurrentSnapshots)
+	} else if time.Duration(c.SnapshotQueueTimeout) != 3*time.Second {
+		t.Fatalf("unexpected snapshot queue timeout: %v", c.SnapshotQueueTimeout)
+	} else if !c.SnapshotChecksum {
+		t.Fatalf("unexpected snapshot checksum: %v", c.SnapshotChecksum)
+	} else if !c.BatchCommands {
+		t.Fatalf("unexpected batch commands: %v", c.BatchCommands)
+	}
+}
+
+// Ensure the configuration survives encoding to TOML and back.
+func TestConfig_Encode(t *testing.T) {
+	c := meta.NewConfig()
+	c.Dir = "/tmp/foo"
+	c.JoinRetryTimeout = itoml.Duration(5 * time.Minute)
+	c.AuthCacheMaxEntries = 100
+
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(c); err != nil {
+		t.Fatal(err)
+	} else if !strings.Contains(buf.String(), `heartbeat-timeout = "1s"`) {
+		t.Fatalf("durations not encoded as strings:\n%s", buf.String())
+	}
+
+	var other meta.Config
+	md, err := toml.Decode(buf.String(), &other)
+	if err != nil {
+		t.Fatal(err)
+	} else if keys := md.Undecoded(); len(keys) != 0 {
+		t.Fatalf("unexpected undecoded keys: %v", keys)
+	} else if !reflect.DeepEqual(&other, c) {
+		t.Fatalf("config mismatch:\n\nexp=%#v\n\ngot=%#v", c, &other)
+	}
+}
+
+// Ensure a zero config is filled with the defaults.
+func TestConfig_ApplyDefaults(t *testing.T) {
+	var c meta.Config
+	c.ApplyDefaults()
+
+	exp := meta.Config{
+		Hostname:              meta.DefaultHostname,
+		BindAddress:           meta.DefaultBindAddress,
+		BindNetwork:           meta.DefaultBindNetwork,
+		ElectionTimeout:       itoml.Duration(meta.DefaultElectionTimeout),
+		HeartbeatTimeout:      itoml.Duration(meta.DefaultHeartbeatTimeout),
+		LeaderLeaseTimeout:    itoml.Duration(meta.DefaultLeaderLeaseTimeout),
+		CommitTimeout:         itoml.Duration(meta.DefaultCommitTimeout),
+		RaftSnapshotInterval:  itoml.Duration(meta.DefaultRaftSnapshotInterval),
+		RaftSnapshotThreshold: meta.DefaultRaftSnapshotThreshold,
+		RaftTransportTimeout:  itoml.Duration(meta.DefaultRaftTransportTimeout),
+		RaftMaxPool:           meta.DefaultRaftMaxPool,
+		RaftStoreType:         meta.DefaultRaftStoreType,
+		LogLevel:              meta.DefaultLogLevel,
+		HashCost:              meta.DefaultHashCost,
+		SnapshotCompression:   meta.DefaultSnapshotCompression,
+		JoinRetryInterval:     itoml.Duration(meta.DefaultJoinRetryInterval),
+		JoinRetryMaxInterval:  itoml.Duration(meta.DefaultJoinRetryMaxInterval),
+		DataDirMode:           meta.DefaultDataDirMode,
+	}
+	if !reflect.DeepEqual(c, exp) {
+		t.Fatalf("unexpected config:\n%#v", c)
+	} else if err := c.Validate(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// Applying again changes nothing.
+	c.ApplyDefaults()
+	if !reflect.DeepEqual(c, exp) {
+		t.Fatalf("unexpected config after second apply:\n%#v", c)
+	}
+
+	// Set fields are kept.
+	c = meta.Config{HashCost: 12, LogLevel: "warn"}
+	c.ApplyDefaults()
+	if c.HashCost != 12 || c.LogLevel != "warn" {
+		t.Fatalf("unexpected config: %#v", c)
+	}
+}
+
+// Ensure the configuration rejects invalid timeouts and limits.
+func TestConfig_Validate(t *testing.T) {
+	for i, tt := range []struct {
+		fn  func(c *meta.Config)
+		err string
+	}{
+		{fn: func(c *meta.Config) {}},
+		{fn: func(c *meta.Config) { c.HeartbeatTimeout = 0 }, err: "Meta.HeartbeatTimeout must be at least 5ms"},
+		{fn: func(c *meta.Config) { c.ElectionTimeout = itoml.Duration(500 * time.Millisecond) }, err: "Meta.ElectionTimeout must be at least Meta.HeartbeatTimeout"},
+		{fn: func(c *meta.Config) { c.LeaderLeaseTimeout = 0 }, err: "Meta.LeaderLeaseTimeout must be at least 5ms"},
+		{fn: func(c *meta.Config) { c.LeaderLeaseTimeout = itoml.Duration(2 * time.Second) }, err: "Meta.LeaderLeaseTimeout must not be greater than Meta.HeartbeatTimeout"},
+		{fn: func(c *meta.Config) { c.CommitTimeout = 0 }, err: "Meta.CommitTimeout must be at least 1ms"},
+		{fn: func(c *meta.Config) { c.RaftSnapshotInterval = 0 }, err: "Meta.RaftSnapshotInterval must be at least 5ms"},
+		{fn: func(c *meta.Config) { c.RaftSnapshotThreshold = -1 }, err: "Meta.RaftSnapshotThreshold must be positive"},
+		{fn: func(c *meta.Config) { c.RaftTransportTimeout = -1 }, err: "Meta.RaftTransportTimeout must be positive"},
+		{fn: func(c *meta.Config) { c.RaftMaxPool = -1 }, err: "Meta.RaftMaxPool must be positive"},
+		{fn: func(c *meta.Config) { c.RaftStoreType = meta.RaftStoreInmem }},
+		{fn: func(c *meta.Config) { c.RaftStoreType = "leveldb" }, err: "unrecognized raft store type leveldb"},
+		{fn: func(c *meta.Config) { c.AuthCacheTTL = -1 }, err: "Meta.AuthCacheTTL must not be negative"},
+		{fn: func(c *meta.Config) { c.AuthCacheMaxEntries = -1 }, err: "Meta.AuthCacheMaxEntries must not be negative"},
+		{fn: func(c *meta.Config) { c.JoinRetryInterval = -1 }, err: "Meta.JoinRetryInterval must not be negative"},
+		{fn: func(c *meta.Config) { c.JoinRetryMaxInterval = -1 }, err: "Meta.JoinRetryMaxInterval must not be negative"},
+		{fn: func(c *meta.Config) { c.JoinRetryTimeout = -1 }, err: "Meta.JoinRetryTimeout must not be negative"},
+		{fn: func(c *meta.Config) { c.JoinTimeout = -1 }, err: "Meta.JoinTimeout must not be negative"},
+		{fn: func(c *meta.Config) { c.RPCTimeout = -1 }, err: "Meta.RPCTimeout must not be negative"},
+		{fn: func(c *meta.Config) { c.ApplyTimeout = -1 }, err: "Meta.ApplyTimeout must not be negative"},
+		{fn: func(c *meta.Config) { c.TCPKeepAlive = -1 }, err: "Meta.TCPKeepAlive must not be negative"},
+		{fn: func(c *meta.Config) { c.LeaderWatchdog = -1 }, err: "Meta.LeaderWatchdog must not be negative"},
+		{fn: func(c *meta.Config) { c.MaxConcurrentSnapshots = -1 }, err: "Meta.MaxConcurrentSnapshots must not be negative"},
+		{fn: func(c *meta.Config) { c.SnapshotQueueTimeout = -1 }, err: "Meta.SnapshotQueueTimeout must not be negative"},
+		{fn: func(c *meta.Config) { c.ApplyLatencyBuckets = []itoml.Duration{0} }, err: "Meta.ApplyLatencyBuckets must be positive"},
+		{fn: func(c *meta.Config) { c.ApplyLatencyBuckets = []itoml.Duration{2, 1} }, err: "Meta.ApplyLatencyBuckets must be in increasing order"},
+		{fn: func(c *meta.Config) { c.DataDirMode = 0750 }},
+		{fn: func(c *meta.Config) { c.DataDirMode = 0500 }, err: "Meta.DataDirMode must be a permission between 0700 and 0777, got 0500"},
+		{fn: func(c *meta.Config) { c.DataDirMode = 04700 }, err: "Meta.DataDirMode must be a permission between 0700 and 0777, got 4700"},
+	} {
+		c := meta.NewConfig()
+		tt.fn(c)
+		if err := c.Validate(); tt.err == "" && err != nil {
+			t.Errorf("%d. unexpected error: %s", i, err)
+		} else if tt.err != "" && (err == nil || err.Error() != tt.err) {
+			t.Errorf("%d. unexpected error: got %v, exp %s", i, err, tt.err)
+		}
+	}
+}
+
+// Ensure the configuration validates the hash cost.
+func TestConfig_Validate_HashCost(t *testing.T) {
+	for _, tt := range []struct {
+		cost  int
+		valid bool
+	}{
+		{cost: 3, valid: false},
+		{cost: 4, valid: true},
+		{cost: 10, valid: true},
+		{cost: 31, valid: true},
+		{cost: 32, valid: false},
+	} {
+		c := meta.NewConfig()
+		c.HashCost = tt.cost
+		if err := c.Validate(); tt.valid && err != nil {
+			t.Errorf("cost %d: unexpected error: %s", tt.cost, err)
+		} else if !tt.valid && err == nil {
+			t.Errorf("cost %d: expected error", tt.cost)
+		}
+	}
+}
+
+// Ensure the configuration validates the bind network.
+func TestConfig_Validate_BindNetwork(t *testing.T) {
+	c := meta.NewConfig()
+	for _, network := range []string{"", "tcp", "tcp4", "tcp6"} {
+		c.BindNetwork = network
+		if err := c.Validate(); err != nil {
+			t.Errorf("%q: unexpected error: %s", network, err)
+		}
+	}
+
+	c.BindNetwork = "udp"
+	if err := c.Validate(); err == nil {
+		t.Error("expected error")
+	}
+}
+
+// Ensure the configuration validates the snapshot compression.
+func TestConfig_Validate_SnapshotCompression(t *testing.T) {
+	c := meta.NewConfig()
+	for _, compression := range []string{"", "none", "gzip"} {
+		c.SnapshotCompression = compression
+		if err := c.Validate(); err != nil {
+			t.Errorf("%q: unexpected error: %s", compression, err)
+		}
+	}
+
+	c.SnapshotCompression = "lz4"
+	if err := c.Validate(); err == nil {
+		t.Error("expected error")
+	}
+}
+
+// Ensure the configuration validates the log level.
+func TestConfig_Validate_LogLevel(t *testing.T) {
+	c := meta.NewConfig()
+	for _, level := range []string{"", "debug", "info", "warn", "error"} {
+		c.LogLevel = level
+		if err := c.Validate(); err != nil {
+			t.Errorf("%q: unexpected error: %s", level, err)
+		}
+	}
+
+	c.LogLevel = "verbose"
+	if err := c.Validate(); err == nil {
+		t.Error("expected error")
 	}
 }
@@ -247,6 +247,11 @@ func (data *Data) DropRetentionPolicy(database, name string) error {
 
 // UpdateRetentionPolicy updates an existing retention policy.
 func (data *Data) UpdateRetentionPolicy(database, name string, rpu *RetentionPolicyUpdate) error {
+	// Validate the fields being updated.
+	if err := rpu.Validate(); err != nil {
+		return err
+	}
+
 	// Find database.
 	di := data.Database(database)
 	if di == nil {
@@ -264,9 +269,10 @@ func (data *Data) UpdateRetentionPolicy(database, name string, rpu *RetentionPol
 		return ErrRetentionPolicyNameExists
 	}
 
-	// Enforce duration of at least MinRetentionPolicyDuration
-	if rpu.Duration != nil && *rpu.Duration < MinRetentionPolicyDuration && *rpu.Duration != 0 {
-		return ErrRetentionPolicyDurationTooLow
+	// Ensure the shard group duration is no longer than the existing
+	// duration when only the shard group duration is updated.
+	if rpu.ShardGroupDuration != nil && rpu.Duration == nil && rpi.Duration != 0 && *rpu.ShardGroupDuration > rpi.Duration {
+		return ErrRetentionPolicyShardGroupDurationTooHigh
 	}
 
 	// Update fields.
@@ -277,6 +283,9 @@ func (data *Data) UpdateRetentionPolicy(database, name string, rpu *RetentionPol
 		rpi.Duration = *rpu.Duration
 		rpi.ShardGroupDuration = shardGroupDuration(rpi.Duration)
 	}
+	if rpu.ShardGroupDuration != nil {
+		rpi.ShardGroupDuration = *rpu.ShardGroupDuration
+	}
 	if rpu.ReplicaN != nil {
 		rpi.ReplicaN = *rpu.ReplicaN
 	}
@@ -729,6 +738,86 @@ func (data *Data) UnmarshalBinary(buf []byte) error {
 	return nil
 }
 
+// DataDiff describes the changes from one version of the metadata to another.
+type DataDiff struct {
+	AddedDatabases   []string
+	RemovedDatabases []string
+
+	// Policies added, removed or with changed settings in databases that
+	// exist in both versions. Shard groups and subscriptions are ignored.
+	ChangedRetentionPolicies []RetentionPolicyChange
+
+	AddedNodes   []NodeInfo
+	RemovedNodes []NodeInfo
+}
+
+// RetentionPolicyChange describes a change to a single retention policy.
+// Old is nil for an added policy and New is nil for a removed policy.
+type RetentionPolicyChange struct {
+	Database string
+	Old      *RetentionPolicyInfo
+	New      *RetentionPolicyInfo
+}
+
+// IsEmpty returns true if the diff contains no changes.
+func (d *DataDiff) IsEmpty() bool {
+	return len(d.AddedDatabases) == 0 && len(d.RemovedDatabases) == 0 &&
+		len(d.ChangedRetentionPolicies) == 0 &&
+		len(d.AddedNodes) == 0 && len(d.RemovedNodes) == 0
+}
+
+// Diff returns the changes from old to data. A nil old is treated as empty.
+// Nothing is allocated when there are no changes.
+func (data *Data) Diff(old *Data) DataDiff {
+	var d DataDiff
+	if old == nil {
+		old = &Data{}
+	}
+	if old == data {
+		return d
+	}
+
+	for i := range data.Databases {
+		di := &data.Databases[i]
+		odi := old.Database(di.Name)
+		if odi == nil {
+			d.AddedDatabases = append(d.AddedDatabases, di.Name)
+			continue
+		}
+
+		for j := range di.RetentionPolicies {
+			rpi := &di.RetentionPolicies[j]
+			if orpi := odi.RetentionPolicy(rpi.Name); orpi == nil || !rpi.settingsEqual(orpi) {
+				d.ChangedRetentionPolicies = append(d.ChangedRetentionPolicies, RetentionPolicyChange{Database: di.Name, Old: orpi, New: rpi})
+			}
+		}
+		for j := range odi.RetentionPolicies {
+			orpi := &odi.RetentionPolicies[j]
+			if di.RetentionPolicy(orpi.Name) == nil {
+				d.ChangedRetentionPolicies = append(d.ChangedRetentionPolicies, RetentionPolicyChange{Database: di.Name, Old: orpi})
+			}
+		}
+	}
+	for i := range old.Databases {
+		if data.Database(old.Databases[i].Name) == nil {
+			d.RemovedDatabases = append(d.RemovedDatabases, old.Databases[i].Name)
+		}
+	}
+
+	for _, n := range data.Nodes {
+		if old.Node(n.ID) == nil {
+			d.AddedNodes = append(d.AddedNodes, n)
+		}
+	}
+	for _, n := range old.Nodes {
+		if data.Node(n.ID) == nil {
+			d.RemovedNodes = append(d.RemovedNodes, n)
+		}
+	}
+
+	return d
+}
+
 // NodeInfo represents information about a single node in the cluster.
 type NodeInfo struct {
 	ID   uint64
@@ -881,6 +970,15 @@ func NewRetentionPolicyInfo(name string) *RetentionPolicyInfo {
 	}
 }
 
+// settingsEqual returns true if both policies have the same name, duration,
+// shard group duration and replication factor.
+func (rpi *RetentionPolicyInfo) settingsEqual(other *RetentionPolicyInfo) bool {
+	return rpi.Name == other.Name &&
+		rpi.Duration == other.Duration &&
+		rpi.ShardGroupDuration == other.ShardGroupDuration &&
+		rpi.ReplicaN == other.ReplicaN
+}
+
 // ShardGroupByTimestamp returns the shard group in the policy that contains the timestamp.
 func (rpi *RetentionPolicyInfo) ShardGroupByTimestamp(timestamp time.Time) *ShardGroupInfo {
 	for i := range rpi.ShardGroups {
@@ -232,6 +232,57 @@ func TestData_UpdateRetentionPolicy(t *testing.T) {
 	}
 }
 
+// Ensure a retention policy's shard group duration can be updated and is
+// left unchanged when not set.
+func TestData_UpdateRetentionPolicy_ShardGroupDuration(t *testing.T) {
+	var data meta.Data
+	if err := data.CreateDatabase("db0"); err != nil {
+		t.Fatal(err)
+	} else if err = data.CreateRetentionPolicy("db0", &meta.RetentionPolicyInfo{Name: "rp0", ReplicaN: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	var rpu meta.RetentionPolicyUpdate
+	rpu.SetDuration(48 * time.Hour)
+	rpu.SetShardGroupDuration(2 * time.Hour)
+	if err := data.UpdateRetentionPolicy("db0", "rp0", &rpu); err != nil {
+		t.Fatal(err)
+	} else if rpi, _ := data.RetentionPolicy("db0", "rp0"); rpi.ShardGroupDuration != 2*time.Hour {
+		t.Fatalf("unexpected shard group duration: %s", rpi.ShardGroupDuration)
+	}
+
+	// Leaving the field nil keeps the current shard group duration.
+	rpu = meta.RetentionPolicyUpdate{}
+	rpu.SetReplicaN(2)
+	if err := data.UpdateRetentionPolicy("db0", "rp0", &rpu); err != nil {
+		t.Fatal(err)
+	} else if rpi, _ := data.RetentionPolicy("db0", "rp0"); rpi.ShardGroupDuration != 2*time.Hour {
+		t.Fatalf("unexpected shard group duration: %s", rpi.ShardGroupDuration)
+	}
+
+	// The shard group duration must be positive.
+	rpu = meta.RetentionPolicyUpdate{}
+	rpu.SetShardGroupDuration(0)
+	if err := data.UpdateRetentionPolicy("db0", "rp0", &rpu); err != meta.ErrRetentionPolicyShardGroupDurationInvalid {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The shard group duration cannot exceed the existing duration...
+	rpu = meta.RetentionPolicyUpdate{}
+	rpu.SetShardGroupDuration(72 * time.Hour)
+	if err := data.UpdateRetentionPolicy("db0", "rp0", &rpu); err != meta.ErrRetentionPolicyShardGroupDurationTooHigh {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// ...unless the duration is updated at the same time.
+	rpu.SetDuration(96 * time.Hour)
+	if err := data.UpdateRetentionPolicy("db0", "rp0", &rpu); err != nil {
+		t.Fatal(err)
+	} else if rpi, _ := data.RetentionPolicy("db0", "rp0"); rpi.ShardGroupDuration != 72*time.Hour {
+		t.Fatalf("unexpected shard group duration: %s", rpi.ShardGroupDuration)
+	}
+}
+
 // Ensure a retention policy can be removed.
 func TestData_DropRetentionPolicy(t *testing.T) {
 	var data meta.Data
@@ -646,6 +697,118 @@ func TestData_UpdateUser_ErrUserNotFound(t *testing.T) {
 	}
 }
 
+// Ensure identical data produces an empty diff without allocating.
+func TestData_Diff_Identical(t *testing.T) {
+	data := MustDiffData()
+	other := data.Clone()
+
+	if d := data.Diff(other); !d.IsEmpty() {
+		t.Fatalf("unexpected diff: %#v", d)
+	}
+	if allocs := testing.AllocsPerRun(100, func() { data.Diff(other) }); allocs != 0 {
+		t.Fatalf("unexpected allocations: %v", allocs)
+	}
+}
+
+// Ensure added and removed databases are reported.
+func TestData_Diff_Databases(t *testing.T) {
+	old := MustDiffData()
+	data := old.Clone()
+	if err := data.DropDatabase("db0"); err != nil {
+		t.Fatal(err)
+	} else if err := data.CreateDatabase("db2"); err != nil {
+		t.Fatal(err)
+	}
+
+	d := data.Diff(old)
+	if !reflect.DeepEqual(d.AddedDatabases, []string{"db2"}) {
+		t.Fatalf("unexpected added databases: %v", d.AddedDatabases)
+	} else if !reflect.DeepEqual(d.RemovedDatabases, []string{"db0"}) {
+		t.Fatalf("unexpected removed databases: %v", d.RemovedDatabases)
+	} else if len(d.ChangedRetentionPolicies) != 0 {
+		t.Fatalf("unexpected retention policy changes: %#v", d.ChangedRetentionPolicies)
+	}
+
+	// A nil old is treated as empty data.
+	if d := data.Diff(nil); !reflect.DeepEqual(d.AddedDatabases, []string{"db1", "db2"}) {
+		t.Fatalf("unexpected added databases: %v", d.AddedDatabases)
+	}
+}
+
+// Ensure added, removed and updated retention policies are reported.
+func TestData_Diff_RetentionPolicies(t *testing.T) {
+	old := MustDiffData()
+	data := old.Clone()
+	if err := data.DropRetentionPolicy("db0", "rp0"); err != nil {
+		t.Fatal(err)
+	} else if err := data.CreateRetentionPolicy("db0", &meta.RetentionPolicyInfo{Name: "rp2", ReplicaN: 1}); err != nil {
+		t.Fatal(err)
+	}
+	var rpu meta.RetentionPolicyUpdate
+	rpu.SetReplicaN(2)
+	if err := data.UpdateRetentionPolicy("db1", "rp1", &rpu); err != nil {
+		t.Fatal(err)
+	}
+
+	// Shard group changes are not policy changes.
+	if err := data.CreateShardGroup("db1", "rp1", time.Unix(0, 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	d := data.Diff(old)
+	if len(d.ChangedRetentionPolicies) != 3 {
+		t.Fatalf("unexpected retention policy changes: %#v", d.ChangedRetentionPolicies)
+	}
+	for _, c := range d.ChangedRetentionPolicies {
+		switch {
+		case c.Database == "db0" && c.Old == nil && c.New.Name == "rp2":
+		case c.Database == "db0" && c.Old.Name == "rp0" && c.New == nil:
+		case c.Database == "db1" && c.Old.ReplicaN == 1 && c.New.ReplicaN == 2:
+		default:
+			t.Fatalf("unexpected retention policy change: %#v", c)
+		}
+	}
+	if len(d.AddedDatabases) != 0 || len(d.RemovedDatabases) != 0 {
+		t.Fatalf("unexpected database changes: %#v", d)
+	}
+}
+
+// Ensure added and removed nodes are reported.
+func TestData_Diff_Nodes(t *testing.T) {
+	old := MustDiffData()
+	data := old.Clone()
+	if err := data.DeleteNode(1, true); err != nil {
+		t.Fatal(err)
+	} else if err := data.CreateNode("host2"); err != nil {
+		t.Fatal(err)
+	}
+
+	d := data.Diff(old)
+	if !reflect.DeepEqual(d.AddedNodes, []meta.NodeInfo{{ID: 3, Host: "host2"}}) {
+		t.Fatalf("unexpected added nodes: %v", d.AddedNodes)
+	} else if !reflect.DeepEqual(d.RemovedNodes, []meta.NodeInfo{{ID: 1, Host: "host0"}}) {
+		t.Fatalf("unexpected removed nodes: %v", d.RemovedNodes)
+	}
+}
+
+// MustDiffData returns data with two nodes and two databases that each
+// have one retention policy.
+func MustDiffData() *meta.Data {
+	data := &meta.Data{}
+	for i := 0; i < 2; i++ {
+		if err := data.CreateNode(fmt.Sprintf("host%d", i)); err != nil {
+			panic(err)
+		}
+		db, rp := fmt.Sprintf("db%d", i), fmt.Sprintf("rp%d", i)
+		if err := data.CreateDatabase(db); err != nil {
+			panic(err)
+		} else if err := data.CreateRetentionPolicy(db, &meta.RetentionPolicyInfo{Name: rp, ReplicaN: 1}); err != nil {
+			panic(err)
+		}
+	}
+	return data
+}
+
 // Ensure the data can be deeply copied.
 func TestData_Clone(t *testing.T) {
 	data := meta.Data{
@@ -3,19 +3,144 @@ package meta
 import (
 	"errors"
 	"fmt"
+	"strings"
 )
 
 var (
 	// ErrStoreOpen is returned when opening an already open store.
 	ErrStoreOpen = newError("store already open")
 
-	// ErrStoreClosed is returned when closing an already closed store.
+	// ErrStoreClosed is returned when using a store that has been closed.
 	ErrStoreClosed = newError("raft store already closed")
 
+	// ErrStoreNotEmpty is returned when restoring a snapshot onto a store
+	// that already holds databases or users.
+	ErrStoreNotEmpty = newError("store is not empty")
+
+	// ErrStoreInCluster is returned when restoring a snapshot onto a store
+	// that shares its raft quorum with other peers.
+	ErrStoreInCluster = newError("store is part of a cluster")
+
+	// ErrDirLocked is returned when opening a store whose directory is in
+	// use by another process. Errors of type *DirLockedError match it.
+	ErrDirLocked = newError("data dir in use")
+
+	// ErrDataDirUnwritable is returned when the store's directory can't be
+	// created. Errors of type *OpenError match it.
+	ErrDataDirUnwritable = newError("data dir not writable")
+
+	// ErrRaftOpen is returned when the raft log or transport can't be
+	// opened. Errors of type *OpenError match it.
+	ErrRaftOpen = newError("unable to open raft")
+
+	// ErrJoinFailed is returned when the store can't join the cluster set
+	// by its peers. Errors of type *OpenError match it.
+	ErrJoinFailed = newError("unable to join cluster")
+
+	// ErrSnapshotCorrupt is returned when a raft snapshot fails its checksum.
+	ErrSnapshotCorrupt = newError("snapshot is corrupt")
+
+	// ErrSnapshotInProgress is returned when a snapshot is forced while an
+	// earlier forced snapshot has not finished.
+	ErrSnapshotInProgress = newError("snapshot already in progress")
+
+	// ErrFetchBusy is returned to a node requesting the meta data when too
+	// many requests are already being served and no slot frees up in time.
+	ErrFetchBusy = newError("too many meta data fetches in progress")
+
 	// ErrTooManyPeers is returned when more than 3 peers are used.
 	ErrTooManyPeers = newError("too many peers; influxdb v0.9.0 is limited to 3 nodes in a cluster")
+
+	// ErrApplyTimeout is returned when a command is not committed within the
+	// apply timeout. The command may still be committed later.
+	ErrApplyTimeout = newError("timed out applying command")
+
+	// ErrNotLeader is returned when a command is applied on a node that is
+	// not the leader. Errors of type *NotLeaderError match it.
+	ErrNotLeader = newError("node is not the leader")
+
+	// ErrLeadershipLost is returned when the leader steps down before a
+	// command is committed. The command may still be committed by the next leader.
+	ErrLeadershipLost = newError("leadership lost while applying command")
+
+	// ErrInvalidCommand is returned when a batch holds a command that can't
+	// be decoded or applied as part of a batch.
+	ErrInvalidCommand = newError("invalid command")
+
+	// ErrBatchDisabled is returned by ApplyBatch when batch-commands is not
+	// enabled.
+	ErrBatchDisabled = newError("batch commands are disabled")
 )
 
+// NotLeaderError is returned when an operation that must run on the leader
+// is attempted on another node.
+type NotLeaderError struct {
+	Leader string // address of the current leader, empty if unknown
+}
+
+// Error returns the string representation of the error.
+func (e *NotLeaderError) Error() string {
+	if e.Leader == "" {
+		return fmt.Sprintf("%s: no leader", ErrNotLeader)
+	}
+	return fmt.Sprintf("%s: leader is %s", ErrNotLeader, e.Leader)
+}
+
+// Is returns true if target is ErrNotLeader.
+func (e *NotLeaderError) Is(target error) bool { return target == ErrNotLeader }
+
+// DirLockedError is returned when opening a store whose directory is locked
+// by another process.
+type DirLockedError struct {
+	Path string // path of the lock file
+	PID  int    // process holding the lock, zero if unknown
+}
+
+// Error returns the string representation of the error.
+func (e *DirLockedError) Error() string {
+	if e.PID == 0 {
+		return fmt.Sprintf("%s: %s", ErrDirLocked, e.Path)
+	}
+	return fmt.Sprintf("%s: %s: held by pid %d", ErrDirLocked, e.Path, e.PID)
+}
+
+// Is returns true if target is ErrDirLocked.
+func (e *DirLockedError) Is(target error) bool { return target == ErrDirLocked }
+
+// OpenError is returned when a step of opening the store fails.
+type OpenError struct {
+	Kind error // ErrDataDirUnwritable, ErrRaftOpen or ErrJoinFailed
+	Err  error // underlying cause
+}
+
+// Error returns the string representation of the error.
+func (e *OpenError) Error() string { return fmt.Sprintf("%s: %s", e.Kind, e.Err) }
+
+// Is returns true if target is the kind of failure.
+func (e *OpenError) Is(target error) bool { return target == e.Kind }
+
+// Unwrap returns the underlying cause.
+func (e *OpenError) Unwrap() error { return e.Err }
+
+// JoinValidationError is returned by ValidateJoin when one or more peers
+// could not be validated.
+type JoinValidationError struct {
+	Peers []string         // peers that were checked, in order
+	Errs  map[string]error // failure of each peer that failed
+}
+
+// Error returns the string representation of the error.
+func (e *JoinValidationError) Error() string {
+	var failed []string
+	for _, peer := range e.Peers {
+		if err, ok := e.Errs[peer]; ok {
+			failed = append(failed, fmt.Sprintf("%s: %s", peer, err))
+		}
+	}
+	return fmt.Sprintf("join validation failed for %d of %d peers: %s",
+		len(e.Errs), len(e.Peers), strings.Join(failed, "; "))
+}
+
 var (
 	// ErrNodeExists is returned when creating an already existing node.
 	ErrNodeExists = newError("node already exists")
@@ -33,8 +158,31 @@ var (
 	// ErrNodeUnableToDropFinalNode is returned if the node being dropped is the last
 	// node in the cluster
 	ErrNodeUnableToDropFinalNode = newError("unable to drop the final node in a cluster")
+
+	// ErrNodeInvalid is returned when the local node state stored on disk
+	// fails validation. Errors of type *InvalidNodeError match it.
+	ErrNodeInvalid = newError("invalid node state")
 )
 
+// InvalidNodeError is returned when a file holding the local node state
+// (the node id or the raft peers) contains an unusable value.
+type InvalidNodeError struct {
+	Path  string // file the value was read from
+	Field string // name of the invalid field
+	Err   error  // underlying reason
+}
+
+// Error returns the string representation of the error.
+func (e *InvalidNodeError) Error() string {
+	return fmt.Sprintf("%s: %s: invalid %s: %s", ErrNodeInvalid, e.Path, e.Field, e.Err)
+}
+
+// Is returns true if target is ErrNodeInvalid.
+func (e *InvalidNodeError) Is(target error) bool { return target == ErrNodeInvalid }
+
+// Unwrap returns the underlying reason.
+func (e *InvalidNodeError) Unwrap() error { return e.Err }
+
 var (
 	// ErrDatabaseExists is returned when creating an already existing database.
 	ErrDatabaseExists = newError("database already exists")
@@ -63,6 +211,14 @@ var (
 	ErrRetentionPolicyDurationTooLow = newError(fmt.Sprintf("retention policy duration must be at least %s",
 		MinRetentionPolicyDuration))
 
+	// ErrRetentionPolicyShardGroupDurationInvalid is returned when updating a
+	// retention policy with a shard group duration that is not positive.
+	ErrRetentionPolicyShardGroupDurationInvalid = newError("retention policy shard group duration must be greater than 0")
+
+	// ErrRetentionPolicyShardGroupDurationTooHigh is returned when updating a
+	// retention policy with a shard group duration longer than its duration.
+	ErrRetentionPolicyShardGroupDurationTooHigh = newError("retention policy shard group duration must not exceed the retention policy duration")
+
 	// ErrReplicationFactorTooLow is returned when the replication factor is not in an
 	// acceptable range.
 	ErrReplicationFactorTooLow = newError("replication factor must be greater than 0")
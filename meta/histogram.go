@@ -0,0 +1,55 @@
+package meta
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// histogram counts durations in buckets with fixed upper bounds. It is safe
+// for concurrent use.
+type histogram struct {
+	mu      sync.Mutex
+	bounds  []time.Duration // sorted upper bounds of each bucket
+	buckets []uint64        // one count per bound, plus one for larger values
+	count   uint64
+	sum     time.Duration
+}
+
+// newHistogram returns a histogram with buckets bounded by bounds, which
+// must be sorted.
+func newHistogram(bounds []time.Duration) *histogram {
+	return &histogram{
+		bounds:  bounds,
+		buckets: make([]uint64, len(bounds)+1),
+	}
+}
+
+// observe records d in the first bucket whose bound is at least d.
+func (h *histogram) observe(d time.Duration) {
+	i := sort.Search(len(h.bounds), func(i int) bool { return h.bounds[i] >= d })
+
+	h.mu.Lock()
+	h.buckets[i]++
+	h.count++
+	h.sum += d
+	h.mu.Unlock()
+}
+
+// stats adds the histogram to stats with keys starting with name. Bucket
+// counts are cumulative: name_le_<bound> counts every duration up to bound,
+// and name_le_inf counts all of them.
+func (h *histogram) stats(name string, stats map[string]string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var n uint64
+	for i, bound := range h.bounds {
+		n += h.buckets[i]
+		stats[name+"_le_"+bound.String()] = strconv.FormatUint(n, 10)
+	}
+	stats[name+"_le_inf"] = strconv.FormatUint(h.count, 10)
+	stats[name+"_count"] = strconv.FormatUint(h.count, 10)
+	stats[name+"_sum"] = h.sum.String()
+}
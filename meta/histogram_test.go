@@ -0,0 +1,28 @@
+package meta
+
+import (
+	"testing"
+	"time"
+)
+
+// Ensure durations are counted in cumulative buckets by their upper bound.
+func TestHistogram_Stats(t *testing.T) {
+	h := newHistogram([]time.Duration{time.Millisecond, time.Second})
+	for _, d := range []time.Duration{time.Microsecond, time.Millisecond, 2 * time.Millisecond, time.Minute} {
+		h.observe(d)
+	}
+
+	stats := make(map[string]string)
+	h.stats("apply_duration", stats)
+	for k, v := range map[string]string{
+		"apply_duration_le_1ms": "2",
+		"apply_duration_le_1s":  "3",
+		"apply_duration_le_inf": "4",
+		"apply_duration_count":  "4",
+		"apply_duration_sum":    "1m0.003001s",
+	} {
+		if stats[k] != v {
+			t.Errorf("unexpected %s: %q, expected %q", k, stats[k], v)
+		}
+	}
+}
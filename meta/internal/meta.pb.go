@@ -43,6 +43,7 @@ It has these top-level messages:
 	CreateSubscriptionCommand
 	DropSubscriptionCommand
 	RemovePeerCommand
+	BatchCommand
 	Response
 	ResponseHeader
 	ErrorResponse
@@ -128,6 +129,7 @@ const (
 	Command_CreateSubscriptionCommand        Command_Type = 21
 	Command_DropSubscriptionCommand          Command_Type = 22
 	Command_RemovePeerCommand                Command_Type = 23
+	Command_BatchCommand                     Command_Type = 24
 )
 
 var Command_Type_name = map[int32]string{
@@ -153,6 +155,7 @@ var Command_Type_name = map[int32]string{
 	21: "CreateSubscriptionCommand",
 	22: "DropSubscriptionCommand",
 	23: "RemovePeerCommand",
+	24: "BatchCommand",
 }
 var Command_Type_value = map[string]int32{
 	"CreateNodeCommand":                1,
@@ -177,6 +180,7 @@ var Command_Type_value = map[string]int32{
 	"CreateSubscriptionCommand":        21,
 	"DropSubscriptionCommand":          22,
 	"RemovePeerCommand":                23,
+	"BatchCommand":                     24,
 }
 
 func (x Command_Type) Enum() *Command_Type {
@@ -852,12 +856,13 @@ var E_SetDefaultRetentionPolicyCommand_Command = &proto.ExtensionDesc{
 }
 
 type UpdateRetentionPolicyCommand struct {
-	Database         *string `protobuf:"bytes,1,req,name=Database" json:"Database,omitempty"`
-	Name             *string `protobuf:"bytes,2,req,name=Name" json:"Name,omitempty"`
-	NewName          *string `protobuf:"bytes,3,opt,name=NewName" json:"NewName,omitempty"`
-	Duration         *int64  `protobuf:"varint,4,opt,name=Duration" json:"Duration,omitempty"`
-	ReplicaN         *uint32 `protobuf:"varint,5,opt,name=ReplicaN" json:"ReplicaN,omitempty"`
-	XXX_unrecognized []byte  `json:"-"`
+	Database           *string `protobuf:"bytes,1,req,name=Database" json:"Database,omitempty"`
+	Name               *string `protobuf:"bytes,2,req,name=Name" json:"Name,omitempty"`
+	NewName            *string `protobuf:"bytes,3,opt,name=NewName" json:"NewName,omitempty"`
+	Duration           *int64  `protobuf:"varint,4,opt,name=Duration" json:"Duration,omitempty"`
+	ReplicaN           *uint32 `protobuf:"varint,5,opt,name=ReplicaN" json:"ReplicaN,omitempty"`
+	ShardGroupDuration *int64  `protobuf:"varint,6,opt,name=ShardGroupDuration" json:"ShardGroupDuration,omitempty"`
+	XXX_unrecognized   []byte  `json:"-"`
 }
 
 func (m *UpdateRetentionPolicyCommand) Reset()         { *m = UpdateRetentionPolicyCommand{} }
@@ -899,6 +904,13 @@ func (m *UpdateRetentionPolicyCommand) GetReplicaN() uint32 {
 	return 0
 }
 
+func (m *UpdateRetentionPolicyCommand) GetShardGroupDuration() int64 {
+	if m != nil && m.ShardGroupDuration != nil {
+		return *m.ShardGroupDuration
+	}
+	return 0
+}
+
 var E_UpdateRetentionPolicyCommand_Command = &proto.ExtensionDesc{
 	ExtendedType:  (*Command)(nil),
 	ExtensionType: (*UpdateRetentionPolicyCommand)(nil),
@@ -1411,6 +1423,30 @@ var E_RemovePeerCommand_Command = &proto.ExtensionDesc{
 	Tag:           "bytes,123,opt,name=command",
 }
 
+type BatchCommand struct {
+	Commands         [][]byte `protobuf:"bytes,1,rep,name=Commands" json:"Commands,omitempty"`
+	XXX_unrecognized []byte   `json:"-"`
+}
+
+func (m *BatchCommand) Reset()         { *m = BatchCommand{} }
+func (m *BatchCommand) String() string { return proto.CompactTextString(m) }
+func (*BatchCommand) ProtoMessage()    {}
+
+func (m *BatchCommand) GetCommands() [][]byte {
+	if m != nil {
+		return m.Commands
+	}
+	return nil
+}
+
+var E_BatchCommand_Command = &proto.ExtensionDesc{
+	ExtendedType:  (*Command)(nil),
+	ExtensionType: (*BatchCommand)(nil),
+	Field:         124,
+	Name:          "internal.BatchCommand.command",
+	Tag:           "bytes,124,opt,name=command",
+}
+
 type Response struct {
 	OK               *bool   `protobuf:"varint,1,req,name=OK" json:"OK,omitempty"`
 	Error            *string `protobuf:"bytes,2,opt,name=Error" json:"Error,omitempty"`
@@ -1487,6 +1523,7 @@ type FetchDataRequest struct {
 	Index            *uint64 `protobuf:"varint,1,req,name=Index" json:"Index,omitempty"`
 	Term             *uint64 `protobuf:"varint,2,req,name=Term" json:"Term,omitempty"`
 	Blocking         *bool   `protobuf:"varint,3,opt,name=Blocking,def=0" json:"Blocking,omitempty"`
+	Version          *uint32 `protobuf:"varint,4,opt,name=Version" json:"Version,omitempty"`
 	XXX_unrecognized []byte  `json:"-"`
 }
 
@@ -1517,6 +1554,13 @@ func (m *FetchDataRequest) GetBlocking() bool {
 	return Default_FetchDataRequest_Blocking
 }
 
+func (m *FetchDataRequest) GetVersion() uint32 {
+	if m != nil && m.Version != nil {
+		return *m.Version
+	}
+	return 0
+}
+
 type FetchDataResponse struct {
 	Header           *ResponseHeader `protobuf:"bytes,1,req,name=Header" json:"Header,omitempty"`
 	Index            *uint64         `protobuf:"varint,2,req,name=Index" json:"Index,omitempty"`
@@ -1559,6 +1603,7 @@ func (m *FetchDataResponse) GetData() []byte {
 
 type JoinRequest struct {
 	Addr             *string `protobuf:"bytes,1,req,name=Addr" json:"Addr,omitempty"`
+	Version          *uint32 `protobuf:"varint,2,opt,name=Version" json:"Version,omitempty"`
 	XXX_unrecognized []byte  `json:"-"`
 }
 
@@ -1573,6 +1618,13 @@ func (m *JoinRequest) GetAddr() string {
 	return ""
 }
 
+func (m *JoinRequest) GetVersion() uint32 {
+	if m != nil && m.Version != nil {
+		return *m.Version
+	}
+	return 0
+}
+
 type JoinResponse struct {
 	Header           *ResponseHeader `protobuf:"bytes,1,req,name=Header" json:"Header,omitempty"`
 	EnableRaft       *bool           `protobuf:"varint,2,opt,name=EnableRaft" json:"EnableRaft,omitempty"`
@@ -1686,4 +1738,5 @@ func init() {
 	proto.RegisterExtension(E_CreateSubscriptionCommand_Command)
 	proto.RegisterExtension(E_DropSubscriptionCommand_Command)
 	proto.RegisterExtension(E_RemovePeerCommand_Command)
+	proto.RegisterExtension(E_BatchCommand_Command)
 }
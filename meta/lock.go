@@ -0,0 +1,52 @@
+package meta
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// errDirLocked is returned by flock when another process holds the lock.
+var errDirLocked = errors.New("locked")
+
+// dirLock is an advisory lock on a store's root directory. It is held for
+// as long as the lock file is open.
+type dirLock struct {
+	f *os.File
+}
+
+// lockDir locks the LOCK file in path and writes the process ID into it.
+// It returns a *DirLockedError if another process holds the lock.
+func lockDir(path string) (*dirLock, error) {
+	lockPath := filepath.Join(path, "LOCK")
+	f, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := flock(f); err == errDirLocked {
+		b, _ := ioutil.ReadAll(f)
+		pid, _ := strconv.Atoi(strings.TrimSpace(string(b)))
+		f.Close()
+		return nil, &DirLockedError{Path: lockPath, PID: pid}
+	} else if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	// Record the holder so a second process can report it.
+	if err := f.Truncate(0); err != nil {
+		f.Close()
+		return nil, err
+	} else if _, err := f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &dirLock{f: f}, nil
+}
+
+// unlock releases the lock. The LOCK file is left in place.
+func (l *dirLock) unlock() error { return l.f.Close() }
@@ -0,0 +1,10 @@
+//go:build windows || plan9 || solaris
+// +build windows plan9 solaris
+
+package meta
+
+import "os"
+
+// flock is a no-op on platforms without flock. The LOCK file is still
+// written but does not stop a second process from opening the directory.
+func flock(f *os.File) error { return nil }
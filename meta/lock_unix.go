@@ -0,0 +1,19 @@
+//go:build !windows && !plan9 && !solaris
+// +build !windows,!plan9,!solaris
+
+package meta
+
+import (
+	"os"
+	"syscall"
+)
+
+// flock takes an exclusive advisory lock on f without blocking.
+func flock(f *os.File) error {
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err == syscall.EWOULDBLOCK {
+		return errDirLocked
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
@@ -1,6 +1,9 @@
 package meta
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -8,6 +11,7 @@ import (
 	"io/ioutil"
 	"log"
 	"net"
+	"sync"
 	"time"
 
 	"github.com/gogo/protobuf/proto"
@@ -15,6 +19,11 @@ import (
 	"github.com/influxdb/influxdb/meta/internal"
 )
 
+// rpcVersion is the version of the meta RPC protocol and of the meta data
+// encoding it carries. Bump it when a change can't be read by nodes running
+// the previous version.
+const rpcVersion = 1
+
 // Max size of a message before we treat the size as invalid
 const (
 	MaxMessageSize    = 1024 * 1024 * 1024
@@ -23,10 +32,39 @@ const (
 
 // rpc handles request/response style messaging between cluster nodes
 type rpc struct {
-	logger         *log.Logger
+	logLevel       logLevel
 	tracingEnabled bool
 
+	// compression is the encoding of meta data sent in fetch responses.
+	compression string
+
+	// timeout bounds each call except blocking fetches. Zero means no limit.
+	timeout time.Duration
+
+	// fetchSlots holds a token for each fetch whose meta data is being
+	// encoded. Fetches wait up to fetchQueueTimeout for a free slot; zero
+	// waits forever. A nil channel doesn't limit fetches.
+	fetchSlots        chan struct{}
+	fetchQueueTimeout time.Duration
+
+	// settingsMu guards logLevel and timeout, which Store.Reload changes.
+	settingsMu sync.RWMutex
+
+	// fetchStats describes the last fetch that returned meta data.
+	fetchMu    sync.Mutex
+	fetchStats fetchStats
+
+	// unversioned holds the hosts already warned about for sending requests
+	// without a protocol version, so each is only logged once.
+	unversionedMu sync.Mutex
+	unversioned   map[string]struct{}
+
+	// store also provides the logger and the clock, which are read on each
+	// use so that changes made to the store after NewStore are seen.
 	store interface {
+		logger() *log.Logger
+		now() time.Time
+		newTimer(d time.Duration) timer
 		cachedData() *Data
 		enableLocalRaft() error
 		IsLeader() bool
@@ -150,14 +188,14 @@ func (r *rpc) executeMessage(conn net.Conn, rpcType internal.RPCType, buf []byte
 		if err := proto.Unmarshal(buf, &req); err != nil {
 			return internal.RPCType_Error, nil, fmt.Errorf("fetch request unmarshal: %v", err)
 		}
-		resp, err := r.handleFetchData(&req)
+		resp, err := r.handleFetchData(&req, remoteHost(conn))
 		return rpcType, resp, err
 	case internal.RPCType_Join:
 		var req internal.JoinRequest
 		if err := proto.Unmarshal(buf, &req); err != nil {
 			return internal.RPCType_Error, nil, fmt.Errorf("join request unmarshal: %v", err)
 		}
-		resp, err := r.handleJoinRequest(&req)
+		resp, err := r.handleJoinRequest(&req, remoteHost(conn))
 		return rpcType, resp, err
 	case internal.RPCType_PromoteRaft:
 		var req internal.PromoteRaftRequest
@@ -175,13 +213,13 @@ func (r *rpc) sendResponse(conn net.Conn, typ internal.RPCType, resp proto.Messa
 	// Marshal the response back to a protobuf
 	buf, err := proto.Marshal(resp)
 	if err != nil {
-		r.logger.Printf("unable to marshal response: %v", err)
+		r.errorf("unable to marshal response: %v", err)
 		return
 	}
 
 	// Encode response back to connection.
 	if _, err := conn.Write(pack(typ, buf)); err != nil {
-		r.logger.Printf("unable to write rpc response: %s", err)
+		r.errorf("unable to write rpc response: %s", err)
 	}
 }
 
@@ -197,8 +235,12 @@ func (r *rpc) sendError(conn net.Conn, msg string) {
 	r.sendResponse(conn, internal.RPCType_Error, resp)
 }
 
-// handleFetchData handles a request for the current nodes meta data
-func (r *rpc) handleFetchData(req *internal.FetchDataRequest) (*internal.FetchDataResponse, error) {
+// handleFetchData handles a request for the current nodes meta data from host.
+func (r *rpc) handleFetchData(req *internal.FetchDataRequest, host string) (*internal.FetchDataResponse, error) {
+	if err := r.checkVersion(req.GetVersion(), host); err != nil {
+		return nil, err
+	}
+
 	var (
 		b    []byte
 		data *Data
@@ -208,7 +250,14 @@ func (r *rpc) handleFetchData(req *internal.FetchDataRequest) (*internal.FetchDa
 	for {
 		data = r.store.cachedData()
 		if data.Index != req.GetIndex() {
+			if err := r.acquireFetch(); err != nil {
+				return nil, err
+			}
 			b, err = data.MarshalBinary()
+			if err == nil {
+				b, err = compressData(b, r.compression)
+			}
+			r.releaseFetch()
 			if err != nil {
 				return nil, err
 			}
@@ -233,9 +282,83 @@ func (r *rpc) handleFetchData(req *internal.FetchDataRequest) (*internal.FetchDa
 		Data:  b}, nil
 }
 
-// handleJoinRequest handles a request to join the cluster
-func (r *rpc) handleJoinRequest(req *internal.JoinRequest) (*internal.JoinResponse, error) {
+// acquireFetch waits for a slot to encode the meta data for a fetch. It
+// returns ErrFetchBusy if no slot frees up within the fetch queue timeout.
+func (r *rpc) acquireFetch() error {
+	if r.fetchSlots == nil {
+		return nil
+	}
+
+	select {
+	case r.fetchSlots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	var timeout <-chan time.Time
+	if r.fetchQueueTimeout > 0 {
+		t := r.store.newTimer(r.fetchQueueTimeout)
+		defer t.Stop()
+		timeout = t.C()
+	}
+
+	select {
+	case r.fetchSlots <- struct{}{}:
+		return nil
+	case <-timeout:
+		r.warnf("Rejected meta data fetch: %d fetches in progress", cap(r.fetchSlots))
+		return ErrFetchBusy
+	}
+}
+
+// releaseFetch frees the slot taken by acquireFetch.
+func (r *rpc) releaseFetch() {
+	if r.fetchSlots != nil {
+		<-r.fetchSlots
+	}
+}
+
+// checkVersion returns an error if a request's protocol version can't be
+// served. Requests from releases that predate versioning carry no version
+// and are served, with a warning the first time host sends one.
+func (r *rpc) checkVersion(v uint32, host string) error {
+	if v == 0 {
+		r.unversionedMu.Lock()
+		_, warned := r.unversioned[host]
+		if !warned {
+			if r.unversioned == nil {
+				r.unversioned = make(map[string]struct{})
+			}
+			r.unversioned[host] = struct{}{}
+		}
+		r.unversionedMu.Unlock()
+
+		if !warned {
+			r.warnf("rpc request from %s has no protocol version: peer may be running an older release", host)
+		}
+		return nil
+	} else if v != rpcVersion {
+		return fmt.Errorf("incompatible meta rpc version: expected %d, got %d", rpcVersion, v)
+	}
+	return nil
+}
+
+// remoteHost returns the host of conn's remote address without the port.
+func remoteHost(conn net.Conn) string {
+	addr := conn.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// handleJoinRequest handles a request from host to join the cluster
+func (r *rpc) handleJoinRequest(req *internal.JoinRequest, host string) (*internal.JoinResponse, error) {
 	r.traceCluster("join request from: %v", *req.Addr)
+	if err := r.checkVersion(req.GetVersion(), host); err != nil {
+		r.errorf("Rejected join request from %v: %v", req.GetAddr(), err)
+		return nil, err
+	}
 
 	node, err := func() (*NodeInfo, error) {
 
@@ -247,7 +370,7 @@ func (r *rpc) handleJoinRequest(req *internal.JoinRequest) (*internal.JoinRespon
 			if err != nil {
 				return node, err
 			}
-			r.logger.Printf("existing node re-joined: id=%v addr=%v", node.ID, node.Host)
+			r.infof("existing node re-joined: id=%v addr=%v", node.ID, node.Host)
 		} else if err != nil {
 			return nil, fmt.Errorf("create node: %v", err)
 		}
@@ -260,7 +383,7 @@ func (r *rpc) handleJoinRequest(req *internal.JoinRequest) (*internal.JoinRespon
 		// If we have less than 3 nodes, add them as raft peers if they are not
 		// already a peer
 		if len(peers) < MaxRaftNodes && !raft.PeerContained(peers, *req.Addr) {
-			r.logger.Printf("adding new raft peer: nodeId=%v addr=%v", node.ID, *req.Addr)
+			r.infof("adding new raft peer: nodeId=%v addr=%v", node.ID, *req.Addr)
 			if err = r.store.AddPeer(*req.Addr); err != nil {
 				return node, fmt.Errorf("add peer: %v", err)
 			}
@@ -347,10 +470,12 @@ func (r *rpc) fetchMetaData(blocking bool) (*Data, error) {
 		index = data.Index
 		term = data.Index
 	}
+	start := r.store.now()
 	resp, err := r.call(leader, &internal.FetchDataRequest{
 		Index:    proto.Uint64(index),
 		Term:     proto.Uint64(term),
 		Blocking: proto.Bool(blocking),
+		Version:  proto.Uint32(rpcVersion),
 	})
 	if err != nil {
 		return nil, err
@@ -362,8 +487,17 @@ func (r *rpc) fetchMetaData(blocking bool) (*Data, error) {
 		if t.GetData() == nil {
 			return nil, nil
 		}
+		r.setFetchStats(fetchStats{
+			bytes:    len(t.GetData()),
+			duration: r.store.now().Sub(start),
+			blocking: blocking,
+		})
+		b, err := decompressData(t.GetData())
+		if err != nil {
+			return nil, fmt.Errorf("rpc decompress metadata: %v", err)
+		}
 		ms := &Data{}
-		if err := ms.UnmarshalBinary(t.GetData()); err != nil {
+		if err := ms.UnmarshalBinary(b); err != nil {
 			return nil, fmt.Errorf("rpc unmarshal metadata: %v", err)
 		}
 		return ms, nil
@@ -374,14 +508,113 @@ func (r *rpc) fetchMetaData(blocking bool) (*Data, error) {
 	}
 }
 
+// fetchMetaDataFrom returns a full copy of the meta data held by peer. It
+// does not consult the local cache or record fetch stats.
+func (r *rpc) fetchMetaDataFrom(ctx context.Context, peer string) (*Data, error) {
+	resp, err := r.callContext(ctx, peer, &internal.FetchDataRequest{
+		Index:    proto.Uint64(0),
+		Term:     proto.Uint64(0),
+		Blocking: proto.Bool(false),
+		Version:  proto.Uint32(rpcVersion),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	switch t := resp.(type) {
+	case *internal.FetchDataResponse:
+		if t.GetData() == nil {
+			return nil, errors.New("peer has no meta data")
+		}
+		b, err := decompressData(t.GetData())
+		if err != nil {
+			return nil, fmt.Errorf("rpc decompress metadata: %v", err)
+		}
+		ms := &Data{}
+		if err := ms.UnmarshalBinary(b); err != nil {
+			return nil, fmt.Errorf("rpc unmarshal metadata: %v", err)
+		}
+		return ms, nil
+	case *internal.ErrorResponse:
+		return nil, fmt.Errorf("rpc failed: %s", t.GetHeader().GetError())
+	default:
+		return nil, fmt.Errorf("rpc failed: unknown response type: %v", t.String())
+	}
+}
+
+// fetchStats describes a fetch of meta data from the leader.
+type fetchStats struct {
+	bytes    int           // size of the data as sent, after compression
+	duration time.Duration // time from request to response
+	blocking bool          // duration includes waiting for a change
+}
+
+func (r *rpc) setFetchStats(fs fetchStats) {
+	r.fetchMu.Lock()
+	r.fetchStats = fs
+	r.fetchMu.Unlock()
+}
+
+// lastFetchStats returns the stats of the last fetch that returned data.
+func (r *rpc) lastFetchStats() fetchStats {
+	r.fetchMu.Lock()
+	defer r.fetchMu.Unlock()
+	return r.fetchStats
+}
+
+// gzipMagic is the header of gzip compressed data. Encoded meta data never
+// starts with these bytes so it can be used to detect compressed data.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// compressData returns b compressed with the given compression.
+func compressData(b []byte, compression string) ([]byte, error) {
+	switch compression {
+	case "", SnapshotCompressionNone:
+		return b, nil
+	case SnapshotCompressionGzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(b); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	default:
+		return nil, fmt.Errorf("unrecognized snapshot compression %s", compression)
+	}
+}
+
+// decompressData returns the uncompressed contents of b. Data that is not
+// compressed is returned as is.
+func decompressData(b []byte) ([]byte, error) {
+	if !bytes.HasPrefix(b, gzipMagic) {
+		return b, nil
+	}
+
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
 // join attempts to join a cluster at remoteAddr using localAddr as the current
 // node's cluster address
 func (r *rpc) join(localAddr, remoteAddr string) (*JoinResult, error) {
+	return r.joinContext(context.Background(), localAddr, remoteAddr)
+}
+
+// joinContext is like join but the call is aborted when ctx is done.
+func (r *rpc) joinContext(ctx context.Context, localAddr, remoteAddr string) (*JoinResult, error) {
 	req := &internal.JoinRequest{
-		Addr: proto.String(localAddr),
+		Addr:    proto.String(localAddr),
+		Version: proto.Uint32(rpcVersion),
 	}
 
-	resp, err := r.call(remoteAddr, req)
+	resp, err := r.callContext(ctx, remoteAddr, req)
 	if err != nil {
 		return nil, err
 	}
@@ -426,6 +659,22 @@ func (r *rpc) enableRaft(addr string, peers []string) error {
 // call sends an encoded request to the remote leader and returns
 // an encoded response value.
 func (r *rpc) call(dest string, req proto.Message) (proto.Message, error) {
+	return r.callContext(context.Background(), dest, req)
+}
+
+// callContext is like call but the call is aborted when ctx is done.
+// Calls other than blocking fetches are also bounded by the rpc timeout.
+func (r *rpc) callContext(ctx context.Context, dest string, req proto.Message) (proto.Message, error) {
+	r.settingsMu.RLock()
+	timeout := r.timeout
+	r.settingsMu.RUnlock()
+
+	if t, ok := req.(*internal.FetchDataRequest); timeout > 0 && !(ok && t.GetBlocking()) {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
 	// Determine type of request
 	var rpcType internal.RPCType
 	switch t := req.(type) {
@@ -440,12 +689,26 @@ func (r *rpc) call(dest string, req proto.Message) (proto.Message, error) {
 	}
 
 	// Create a connection to the leader.
-	conn, err := net.DialTimeout("tcp", dest, leaderDialTimeout)
+	dialer := &net.Dialer{Timeout: leaderDialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", dest)
 	if err != nil {
 		return nil, fmt.Errorf("rpc dial: %v", err)
 	}
 	defer conn.Close()
 
+	// Abort reads and writes once the context is done.
+	if ctx.Done() != nil {
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			select {
+			case <-ctx.Done():
+				conn.SetDeadline(time.Now())
+			case <-done:
+			}
+		}()
+	}
+
 	// Write a marker byte for rpc messages.
 	_, err = conn.Write([]byte{MuxRPCHeader})
 	if err != nil {
@@ -464,6 +727,9 @@ func (r *rpc) call(dest string, req proto.Message) (proto.Message, error) {
 
 	data, err := ioutil.ReadAll(conn)
 	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("read %v rpc: %v", rpcType, ctx.Err())
+		}
 		return nil, fmt.Errorf("read %v rpc: %v", rpcType, err)
 	}
 
@@ -512,10 +778,33 @@ func (r *rpc) call(dest string, req proto.Message) (proto.Message, error) {
 
 func (r *rpc) traceCluster(msg string, args ...interface{}) {
 	if r.tracingEnabled {
-		r.logger.Printf("rpc: "+msg, args...)
+		r.infof("rpc: "+msg, args...)
 	}
 }
 
+// logf writes a message to the logger if level is at or above the rpc's level.
+func (r *rpc) logf(level logLevel, format string, v ...interface{}) {
+	r.settingsMu.RLock()
+	min := r.logLevel
+	r.settingsMu.RUnlock()
+
+	if level >= min {
+		r.store.logger().Printf(format, v...)
+	}
+}
+
+func (r *rpc) infof(format string, v ...interface{})  { r.logf(logLevelInfo, format, v...) }
+func (r *rpc) warnf(format string, v ...interface{})  { r.logf(logLevelWarn, format, v...) }
+func (r *rpc) errorf(format string, v ...interface{}) { r.logf(logLevelError, format, v...) }
+
+// reload replaces the log level and call timeout of the rpc.
+func (r *rpc) reload(level logLevel, timeout time.Duration) {
+	r.settingsMu.Lock()
+	defer r.settingsMu.Unlock()
+	r.logLevel = level
+	r.timeout = timeout
+}
+
 func u64tob(v uint64) []byte {
 	b := make([]byte, 8)
 	binary.BigEndian.PutUint64(b, v)
@@ -1,9 +1,20 @@
 package meta
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
 	"net"
+	"strings"
 	"sync"
 	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/influxdb/influxdb/meta/internal"
 )
 
 func TestRPCFetchData(t *testing.T) {
@@ -43,6 +54,240 @@ func TestRPCFetchData(t *testing.T) {
 	}
 }
 
+// Ensure the size and duration of the last fetch are recorded, with the
+// duration measured by the rpc's clock.
+func TestRPCFetchData_Stats(t *testing.T) {
+	data := &Data{Index: 99}
+	if err := data.CreateDatabase("db0"); err != nil {
+		t.Fatal(err)
+	}
+	b, err := data.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serverRPC := &rpc{
+		store: &fakeStore{md: data},
+	}
+
+	srv := newTestServer(t, serverRPC)
+	defer srv.Close()
+	go srv.Serve()
+	<-srv.Ready
+
+	clientRPC := &rpc{
+		store: &fakeStore{
+			leader: srv.Listener.Addr().String(),
+			clock:  stepClock{fakeClock: newFakeClock(), step: time.Second},
+		},
+	}
+	if fs := clientRPC.lastFetchStats(); fs.bytes != 0 || fs.duration != 0 {
+		t.Fatalf("unexpected stats before fetch: %+v", fs)
+	}
+
+	if _, err := clientRPC.fetchMetaData(false); err != nil {
+		t.Fatalf("failed to fetchMetaData: %v", err)
+	}
+
+	fs := clientRPC.lastFetchStats()
+	if fs.bytes != len(b) {
+		t.Fatalf("unexpected bytes: got %d, exp %d", fs.bytes, len(b))
+	} else if fs.duration != time.Second {
+		t.Fatalf("unexpected duration: %v", fs.duration)
+	} else if fs.blocking {
+		t.Fatal("unexpected blocking fetch")
+	}
+}
+
+// stepClock is a fake clock that moves forward by step each time it is read.
+type stepClock struct {
+	*fakeClock
+	step time.Duration
+}
+
+func (c stepClock) Now() time.Time {
+	c.Add(c.step)
+	return c.fakeClock.Now()
+}
+
+// Ensure fetches beyond the limit wait for a free slot and fail once the
+// queue timeout passes.
+func TestRPCFetchData_MaxConcurrent(t *testing.T) {
+	c := newFakeClock()
+	r := &rpc{
+		store:             &fakeStore{md: &Data{Index: 99}, clock: c},
+		fetchSlots:        make(chan struct{}, 2),
+		fetchQueueTimeout: time.Second,
+	}
+	req := &internal.FetchDataRequest{
+		Index:   proto.Uint64(0),
+		Version: proto.Uint32(rpcVersion),
+	}
+
+	// Take every slot, as fetches being encoded would.
+	for i := 0; i < cap(r.fetchSlots); i++ {
+		if err := r.acquireFetch(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A waiting fetch fails once the clock passes the queue timeout.
+	done := make(chan error, 1)
+	go func() {
+		_, err := r.handleFetchData(req, "127.0.0.1")
+		done <- err
+	}()
+	c.waitForWaiters(1)
+	c.Add(time.Second)
+	if err := <-done; err != ErrFetchBusy {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// A waiting fetch is served once a slot frees up.
+	go func() {
+		_, err := r.handleFetchData(req, "127.0.0.1")
+		done <- err
+	}()
+	c.waitForWaiters(1)
+	r.releaseFetch()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("queued fetch not served")
+	}
+	if n := len(r.fetchSlots); n != 1 {
+		t.Fatalf("unexpected slots in use: %d", n)
+	}
+}
+
+// Ensure requests with a different protocol version are rejected and that
+// requests from releases without a version are served with a warning.
+func TestRPCVersion(t *testing.T) {
+	for i, tt := range []struct {
+		req    proto.Message
+		err    string
+		warned bool
+	}{
+		{req: &internal.FetchDataRequest{Index: proto.Uint64(0), Term: proto.Uint64(0), Version: proto.Uint32(rpcVersion)}},
+		{req: &internal.FetchDataRequest{Index: proto.Uint64(0), Term: proto.Uint64(0)}, warned: true},
+		{
+			req: &internal.FetchDataRequest{Index: proto.Uint64(0), Term: proto.Uint64(0), Version: proto.Uint32(rpcVersion + 1)},
+			err: fmt.Sprintf("incompatible meta rpc version: expected %d, got %d", rpcVersion, rpcVersion+1),
+		},
+		{
+			req: &internal.JoinRequest{Addr: proto.String("1.2.3.4:1234"), Version: proto.Uint32(rpcVersion + 1)},
+			err: fmt.Sprintf("incompatible meta rpc version: expected %d, got %d", rpcVersion, rpcVersion+1),
+		},
+	} {
+		var buf bytes.Buffer
+		serverRPC := &rpc{
+			store: &fakeStore{md: &Data{Index: 99}, newNodeID: 100, out: log.New(&buf, "", 0)},
+		}
+		srv := newTestServer(t, serverRPC)
+		go srv.Serve()
+		<-srv.Ready
+
+		clientRPC := &rpc{store: &fakeStore{}}
+		_, err := clientRPC.call(srv.Listener.Addr().String(), tt.req)
+		srv.Close()
+		if tt.err == "" && err != nil {
+			t.Errorf("%d. unexpected error: %v", i, err)
+		} else if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("%d. unexpected error: got %v, exp %q", i, err, tt.err)
+		}
+		if warned := strings.Contains(buf.String(), "no protocol version"); warned != tt.warned {
+			t.Errorf("%d. unexpected warning: %q", i, buf.String())
+		}
+	}
+}
+
+// Ensure requests without a version are only warned about once per host.
+func TestRPCVersion_WarnOnce(t *testing.T) {
+	var buf bytes.Buffer
+	r := &rpc{store: &fakeStore{out: log.New(&buf, "", 0)}}
+
+	for _, host := range []string{"10.0.0.1", "10.0.0.1", "10.0.0.2"} {
+		if err := r.checkVersion(0, host); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := strings.Count(buf.String(), "no protocol version"); n != 2 {
+		t.Fatalf("unexpected warnings: %q", buf.String())
+	}
+}
+
+func TestRPCFetchDataGzip(t *testing.T) {
+	serverRPC := &rpc{
+		store: &fakeStore{
+			md: &Data{Index: 99},
+		},
+		compression: SnapshotCompressionGzip,
+	}
+
+	srv := newTestServer(t, serverRPC)
+	defer srv.Close()
+	go srv.Serve()
+
+	// Wait for the RPC server to be ready
+	<-srv.Ready
+
+	// create a new RPC with no existing meta.Data cache
+	clientRPC := &rpc{
+		store: &fakeStore{
+			leader: srv.Listener.Addr().String(),
+		},
+	}
+
+	// fetch the servers compressed meta-data
+	md, err := clientRPC.fetchMetaData(false)
+	if err != nil {
+		t.Fatalf("failed to fetchMetaData: %v", err)
+	}
+
+	if md == nil {
+		t.Fatalf("meta-data is nil")
+	}
+
+	if exp := uint64(99); md.Index != exp {
+		t.Fatalf("meta-data mismatch. got %v, exp %v", md.Index, exp)
+	}
+}
+
+func TestRPCCompressData(t *testing.T) {
+	data := &Data{Index: 99}
+	for i := 0; i < 100; i++ {
+		data.CreateDatabase(fmt.Sprintf("db%d", i))
+	}
+	b, err := data.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, compression := range []string{SnapshotCompressionNone, SnapshotCompressionGzip} {
+		compressed, err := compressData(b, compression)
+		if err != nil {
+			t.Fatalf("%s: failed to compress: %v", compression, err)
+		}
+
+		if compression == SnapshotCompressionGzip && len(compressed) >= len(b) {
+			t.Fatalf("%s: size not reduced. got %d, exp < %d", compression, len(compressed), len(b))
+		}
+
+		decompressed, err := decompressData(compressed)
+		if err != nil {
+			t.Fatalf("%s: failed to decompress: %v", compression, err)
+		}
+
+		if !bytes.Equal(decompressed, b) {
+			t.Fatalf("%s: round trip mismatch", compression)
+		}
+	}
+}
+
 func TestRPCFetchDataMatchesLeader(t *testing.T) {
 	serverRPC := &rpc{
 		store: &fakeStore{
@@ -176,12 +421,60 @@ func TestRPCJoin(t *testing.T) {
 	}
 }
 
+func TestRPCJoinTimeout(t *testing.T) {
+	ln := newStalledListener(t)
+	defer ln.Close()
+
+	clientRPC := &rpc{store: &fakeStore{}, timeout: 50 * time.Millisecond}
+	if _, err := clientRPC.join("1.2.3.4:1234", ln.Addr().String()); err == nil {
+		t.Fatal("expected timeout error")
+	} else if !strings.Contains(err.Error(), context.DeadlineExceeded.Error()) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestRPCJoinContextCancel(t *testing.T) {
+	ln := newStalledListener(t)
+	defer ln.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	clientRPC := &rpc{store: &fakeStore{}}
+	if _, err := clientRPC.joinContext(ctx, "1.2.3.4:1234", ln.Addr().String()); err == nil {
+		t.Fatal("expected cancel error")
+	} else if !strings.Contains(err.Error(), context.Canceled.Error()) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// newStalledListener returns a listener that accepts connections but never responds.
+func newStalledListener(t *testing.T) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(ioutil.Discard, conn)
+		}
+	}()
+	return ln
+}
+
 type fakeStore struct {
 	mu        sync.RWMutex
 	leader    string
 	newNodeID uint64
 	md        *Data
 	blockChan chan struct{}
+
+	out   *log.Logger // nil discards log messages
+	clock clock       // nil uses the real clock
 }
 
 type testServer struct {
@@ -222,6 +515,27 @@ func (s *testServer) Serve() {
 	s.rpc.handleRPCConn(conn)
 }
 
+func (f *fakeStore) logger() *log.Logger {
+	if f.out == nil {
+		return log.New(ioutil.Discard, "", 0)
+	}
+	return f.out
+}
+
+func (f *fakeStore) now() time.Time {
+	if f.clock == nil {
+		return time.Now()
+	}
+	return f.clock.Now()
+}
+
+func (f *fakeStore) newTimer(d time.Duration) timer {
+	if f.clock == nil {
+		return realClock{}.NewTimer(d)
+	}
+	return f.clock.NewTimer(d)
+}
+
 func (f *fakeStore) cachedData() *Data {
 	f.mu.RLock()
 	defer f.mu.RUnlock()
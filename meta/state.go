@@ -25,6 +25,7 @@ type raftState interface {
 	initialize() error
 	leader() string
 	isLeader() bool
+	verifyLeader() error
 	sync(index uint64, timeout time.Duration) error
 	setPeers(addrs []string) error
 	addPeer(addr string) error
@@ -33,9 +34,11 @@ type raftState interface {
 	invalidate() error
 	close() error
 	lastIndex() uint64
-	apply(b []byte) error
+	appliedIndex() uint64
+	apply(b []byte, timeout time.Duration) error
 	snapshot() error
 	isLocal() bool
+	stats() map[string]string
 }
 
 // localRaft is a consensus strategy that uses a local raft implementation for
@@ -47,10 +50,46 @@ type localRaft struct {
 	raft      *raft.Raft
 	transport *raft.NetworkTransport
 	peerStore raft.PeerStore
-	raftStore *raftboltdb.BoltStore
+	raftStore raftLogStore
 	raftLayer *raftLayer
 }
 
+// raftLogStore is the storage of the raft log and its stable state.
+type raftLogStore interface {
+	raft.LogStore
+	raft.StableStore
+	Close() error
+}
+
+// inmemLogStore is a raftLogStore kept in memory.
+type inmemLogStore struct {
+	*raft.InmemStore
+}
+
+// Close does nothing; the log is dropped with the store.
+func (inmemLogStore) Close() error { return nil }
+
+// openStores opens the raft log and snapshot stores of the store's raft
+// store type.
+func (r *localRaft) openStores() (raftLogStore, raft.SnapshotStore, error) {
+	s := r.store
+	if s.raftStoreType == RaftStoreInmem {
+		return inmemLogStore{raft.NewInmemStore()}, raft.NewDiscardSnapshotStore(), nil
+	}
+
+	store, err := raftboltdb.NewBoltStore(filepath.Join(s.path, "raft.db"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("new bolt store: %s", err)
+	}
+
+	snapshots, err := raft.NewFileSnapshotStore(s.path, raftSnapshotsRetained, os.Stderr)
+	if err != nil {
+		store.Close()
+		return nil, nil, fmt.Errorf("file snapshot store: %s", err)
+	}
+	return store, snapshots, nil
+}
+
 func (r *localRaft) remove() error {
 	if err := os.RemoveAll(filepath.Join(r.store.path, "raft.db")); err != nil {
 		return err
@@ -77,7 +116,7 @@ func (r *localRaft) updateMetaData(ms *Data) {
 	r.store.mu.RUnlock()
 
 	if updated {
-		r.store.Logger.Printf("Updating metastore to term=%v index=%v", ms.Term, ms.Index)
+		r.store.infof("Updating metastore to term=%v index=%v", ms.Term, ms.Index)
 		r.store.mu.Lock()
 		r.store.data = ms
 		// Signal any blocked goroutines that the meta store has been updated
@@ -100,11 +139,9 @@ func (r *localRaft) invalidate() error {
 	return nil
 }
 
-func (r *localRaft) open() error {
-	r.closing = make(chan struct{})
-
+// raftConfig returns the raft configuration built from the store's settings.
+func (r *localRaft) raftConfig() *raft.Config {
 	s := r.store
-	// Setup raft configuration.
 	config := raft.DefaultConfig()
 	config.LogOutput = ioutil.Discard
 
@@ -115,6 +152,12 @@ func (r *localRaft) open() error {
 	config.ElectionTimeout = s.ElectionTimeout
 	config.LeaderLeaseTimeout = s.LeaderLeaseTimeout
 	config.CommitTimeout = s.CommitTimeout
+	if s.SnapshotInterval > 0 {
+		config.SnapshotInterval = s.SnapshotInterval
+	}
+	if s.SnapshotThreshold > 0 {
+		config.SnapshotThreshold = s.SnapshotThreshold
+	}
 	// Since we actually never call `removePeer` this is safe.
 	// If in the future we decide to call remove peer we have to re-evaluate how to handle this
 	config.ShutdownOnRemove = false
@@ -125,12 +168,34 @@ func (r *localRaft) open() error {
 		// Ensure we can always become the leader
 		config.DisableBootstrapAfterElect = false
 	}
+	return config
+}
+
+// transportConfig returns the connection pool size and timeout of the raft
+// transport built from the store's settings.
+func (r *localRaft) transportConfig() (maxPool int, timeout time.Duration) {
+	maxPool, timeout = DefaultRaftMaxPool, DefaultRaftTransportTimeout
+	if r.store.TransportMaxPool > 0 {
+		maxPool = r.store.TransportMaxPool
+	}
+	if r.store.TransportTimeout > 0 {
+		timeout = r.store.TransportTimeout
+	}
+	return maxPool, timeout
+}
+
+func (r *localRaft) open() error {
+	r.closing = make(chan struct{})
+
+	s := r.store
+	config := r.raftConfig()
 
 	// Build raft layer to multiplex listener.
 	r.raftLayer = newRaftLayer(s.RaftListener, s.RemoteAddr)
 
 	// Create a transport layer
-	r.transport = raft.NewNetworkTransport(r.raftLayer, 3, 10*time.Second, config.LogOutput)
+	maxPool, timeout := r.transportConfig()
+	r.transport = raft.NewNetworkTransport(r.raftLayer, maxPool, timeout, config.LogOutput)
 
 	// Create peer storage.
 	r.peerStore = raft.NewJSONPeers(s.path, r.transport)
@@ -154,23 +219,17 @@ func (r *localRaft) open() error {
 	// is difficult to resolve automatically because we need to have all the raft peers agree on the current members
 	// of the cluster before we can change them.
 	if len(peers) > 0 && !raft.PeerContained(peers, s.RemoteAddr.String()) {
-		s.Logger.Printf("%s is not in the list of raft peers. Please update %v/peers.json on all raft nodes to have the same contents.", s.RemoteAddr.String(), s.Path())
+		s.errorf("%s is not in the list of raft peers. Please update %v/peers.json on all raft nodes to have the same contents.", s.RemoteAddr.String(), s.Path())
 		return fmt.Errorf("peers out of sync: %v not in %v", s.RemoteAddr.String(), peers)
 	}
 
-	// Create the log store and stable store.
-	store, err := raftboltdb.NewBoltStore(filepath.Join(s.path, "raft.db"))
+	// Create the log, stable and snapshot stores.
+	store, snapshots, err := r.openStores()
 	if err != nil {
-		return fmt.Errorf("new bolt store: %s", err)
+		return err
 	}
 	r.raftStore = store
 
-	// Create the snapshot store.
-	snapshots, err := raft.NewFileSnapshotStore(s.path, raftSnapshotsRetained, os.Stderr)
-	if err != nil {
-		return fmt.Errorf("file snapshot store: %s", err)
-	}
-
 	// Create raft log.
 	ra, err := raft.NewRaft(config, (*storeFSM)(s), store, store, snapshots, r.peerStore, r.transport)
 	if err != nil {
@@ -187,7 +246,7 @@ func (r *localRaft) open() error {
 func (r *localRaft) logLeaderChanges() {
 	defer r.wg.Done()
 	// Logs our current state (Node at 1.2.3.4:8088 [Follower])
-	r.store.Logger.Printf(r.raft.String())
+	r.store.infof("%s", r.raft.String())
 	for {
 		select {
 		case <-r.closing:
@@ -195,9 +254,10 @@ func (r *localRaft) logLeaderChanges() {
 		case <-r.raft.LeaderCh():
 			peers, err := r.peers()
 			if err != nil {
-				r.store.Logger.Printf("failed to lookup peers: %v", err)
+				r.store.errorf("failed to lookup peers: %v", err)
 			}
-			r.store.Logger.Printf("%v. peers=%v", r.raft.String(), peers)
+			r.store.infof("%v. peers=%v", r.raft.String(), peers)
+			r.store.notifyLeaderChanged(r.raft.Leader())
 		}
 	}
 }
@@ -247,11 +307,37 @@ func (r *localRaft) initialize() error {
 }
 
 // apply applies a serialized command to the raft log.
-func (r *localRaft) apply(b []byte) error {
+func (r *localRaft) apply(b []byte, timeout time.Duration) error {
 	// Apply to raft log.
-	f := r.raft.Apply(b, 0)
-	if err := f.Error(); err != nil {
-		return err
+	f := r.raft.Apply(b, timeout)
+
+	// Raft only bounds the time taken to enqueue the command so wait for
+	// the commit separately.
+	errCh := make(chan error, 1)
+	go func() { errCh <- f.Error() }()
+
+	var timeoutCh <-chan time.Time
+	if timeout > 0 {
+		t := r.store.clock.NewTimer(timeout)
+		defer t.Stop()
+		timeoutCh = t.C()
+	}
+
+	select {
+	case err := <-errCh:
+		switch err {
+		case nil:
+		case raft.ErrEnqueueTimeout:
+			return ErrApplyTimeout
+		case raft.ErrLeadershipLost:
+			return ErrLeadershipLost
+		case raft.ErrNotLeader:
+			return &NotLeaderError{Leader: r.leader()}
+		default:
+			return err
+		}
+	case <-timeoutCh:
+		return ErrApplyTimeout
 	}
 
 	// Return response if it's an error.
@@ -269,18 +355,26 @@ func (r *localRaft) lastIndex() uint64 {
 	return r.raft.LastIndex()
 }
 
+// appliedIndex returns the last raft log index handed to the FSM.
+func (r *localRaft) appliedIndex() uint64 {
+	if r.raft == nil {
+		return 0
+	}
+	return r.raft.AppliedIndex()
+}
+
 func (r *localRaft) sync(index uint64, timeout time.Duration) error {
-	ticker := time.NewTicker(100 * time.Millisecond)
+	ticker := r.store.clock.NewTicker(100 * time.Millisecond)
 	defer ticker.Stop()
 
-	timer := time.NewTimer(timeout)
+	timer := r.store.clock.NewTimer(timeout)
 	defer timer.Stop()
 
 	for {
 		// Wait for next tick or timeout.
 		select {
-		case <-ticker.C:
-		case <-timer.C:
+		case <-ticker.C():
+		case <-timer.C():
 			return errors.New("timeout")
 		}
 
@@ -336,6 +430,9 @@ func (r *localRaft) setPeers(addrs []string) error {
 }
 
 func (r *localRaft) peers() ([]string, error) {
+	if r.peerStore == nil {
+		return nil, nil
+	}
 	return r.peerStore.Peers()
 }
 
@@ -354,10 +451,32 @@ func (r *localRaft) isLeader() bool {
 	return r.raft.State() == raft.Leader
 }
 
+// verifyLeader confirms with a quorum of peers that this node is still the
+// leader.
+func (r *localRaft) verifyLeader() error {
+	if r.raft == nil {
+		return &NotLeaderError{}
+	}
+	if err := r.raft.VerifyLeader().Error(); err == raft.ErrNotLeader {
+		return &NotLeaderError{Leader: r.leader()}
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
 func (r *localRaft) isLocal() bool {
 	return true
 }
 
+// stats returns the raft statistics or nil if raft is not open.
+func (r *localRaft) stats() map[string]string {
+	if r.raft == nil {
+		return nil
+	}
+	return r.raft.Stats()
+}
+
 // remoteRaft is a consensus strategy that uses a remote raft cluster for
 // consensus operations.
 type remoteRaft struct {
@@ -381,7 +500,7 @@ func (r *remoteRaft) updateMetaData(ms *Data) {
 	r.store.mu.RUnlock()
 
 	if updated {
-		r.store.Logger.Printf("Updating metastore to term=%v index=%v", ms.Term, ms.Index)
+		r.store.infof("Updating metastore to term=%v index=%v", ms.Term, ms.Index)
 		r.store.mu.Lock()
 		r.store.data = ms
 		// Signal any blocked goroutines that the meta store has been updated
@@ -409,7 +528,7 @@ func (r *remoteRaft) setPeers(addrs []string) error {
 	}
 
 	// Write out as JSON
-	return ioutil.WriteFile(filepath.Join(r.store.path, "peers.json"), buf.Bytes(), 0755)
+	return writeFileAtomic(filepath.Join(r.store.path, "peers.json"), buf.Bytes(), 0755)
 }
 
 // addPeer adds addr to the list of peers in the cluster.
@@ -441,7 +560,7 @@ func (r *remoteRaft) open() error {
 
 			ms, err := r.store.rpc.fetchMetaData(true)
 			if err != nil {
-				r.store.Logger.Printf("fetch metastore: %v", err)
+				r.store.errorf("fetch metastore: %v", err)
 				time.Sleep(time.Second)
 				continue
 			}
@@ -456,7 +575,7 @@ func (r *remoteRaft) close() error {
 }
 
 // apply applies a serialized command to the raft log.
-func (r *remoteRaft) apply(b []byte) error {
+func (r *remoteRaft) apply(b []byte, timeout time.Duration) error {
 	return fmt.Errorf("cannot apply log while in remote raft state")
 }
 
@@ -476,14 +595,29 @@ func (r *remoteRaft) isLeader() bool {
 	return false
 }
 
+func (r *remoteRaft) verifyLeader() error {
+	return &NotLeaderError{Leader: r.leader()}
+}
+
 func (r *remoteRaft) isLocal() bool {
 	return false
 }
 
+// stats returns nil since there is no local raft.
+func (r *remoteRaft) stats() map[string]string {
+	return nil
+}
+
 func (r *remoteRaft) lastIndex() uint64 {
 	return r.store.cachedData().Index
 }
 
+// appliedIndex returns the index of the cached data since a remote node
+// applies the leader's data as a whole.
+func (r *remoteRaft) appliedIndex() uint64 {
+	return r.store.cachedData().Index
+}
+
 func (r *remoteRaft) sync(index uint64, timeout time.Duration) error {
 	//FIXME: jwilder: check index and timeout
 	return r.store.invalidate()
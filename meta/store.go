@@ -2,11 +2,14 @@ package meta
 
 import (
 	"bytes"
+	"context"
 	crand "crypto/rand"
 	"crypto/sha256"
 	"encoding/binary"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"io/ioutil"
 	"log"
@@ -16,6 +19,7 @@ import (
 	"path/filepath"
 	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -65,11 +69,14 @@ const (
 
 // Store represents a raft-backed metastore.
 type Store struct {
-	mu     sync.RWMutex
-	path   string
-	opened bool
+	mu      sync.RWMutex
+	path    string
+	dirMode os.FileMode // permission of path when it is created
+	opened  bool
+	lock    *dirLock // held on path while open
 
-	id uint64 // local node id
+	id        uint64 // local node id
+	recoverID bool   // id file was empty, so the node may already exist
 
 	// All peers in cluster. Used during bootstrapping.
 	peers []string
@@ -87,8 +94,32 @@ type Store struct {
 	err     chan error
 	closing chan struct{}
 	wg      sync.WaitGroup
+
+	// changed is closed when the data changes. It is only made once a
+	// caller waits for a change, so changes with no one waiting don't have
+	// to replace it.
 	changed chan struct{}
 
+	// leaderCh receives the leader's address on leadership changes.
+	leaderCh chan string
+
+	// leaderChanged is closed and replaced on each leadership change. It has
+	// its own lock because raft is closed while s.mu is held.
+	leaderMu      sync.Mutex
+	leaderChanged chan struct{}
+
+	// snapshotFns are called after each snapshot is persisted.
+	snapshotFns []func(index uint64)
+
+	// snapshotting is set while a forced snapshot runs. It has its own lock
+	// because taking the snapshot needs s.mu.
+	snapshotMu   sync.Mutex
+	snapshotting bool
+
+	// joinRetries is the number of failed join requests before this node
+	// last joined a cluster.
+	joinRetries int
+
 	// clusterTracingEnabled controls whether low-level cluster communication is logged.
 	// Useful for troubleshooting
 	clusterTracingEnabled bool
@@ -118,8 +149,33 @@ type Store struct {
 	// The amount of time without an apply before sending a heartbeat.
 	CommitTimeout time.Duration
 
+	// The amount of time to wait for a command to commit. Zero waits forever.
+	ApplyTimeout time.Duration
+
+	// How often raft checks whether to snapshot, and how many new log
+	// entries it needs to take one. Zero uses the raft defaults.
+	SnapshotInterval  time.Duration
+	SnapshotThreshold uint64
+
+	// The time limit for sending a message to a raft peer, and the number
+	// of idle connections kept to each peer. Zero uses the defaults.
+	TransportTimeout time.Duration
+	TransportMaxPool int
+
+	// raftStoreType selects the storage of the raft log and snapshots.
+	raftStoreType string
+
+	// snapshotChecksum prefixes persisted snapshots with a checksum header.
+	snapshotChecksum bool
+
+	// batchCommands allows ApplyBatch.
+	batchCommands bool
+
 	// Authentication cache.
-	authCache map[string]authUser
+	authCache *authCache
+
+	// authCacheTTL is how long an authCache entry is valid. Zero disables expiry.
+	authCacheTTL time.Duration
 
 	// hashPassword generates a cryptographically secure hash for password.
 	// Returns an error if the password is invalid or a hash cannot be generated.
@@ -129,25 +185,59 @@ type Store struct {
 	// promoted to a raft node to self-heal a raft cluster
 	raftPromotionEnabled bool
 
-	Logger *log.Logger
-}
+	// Join retries back off exponentially from joinRetryInterval up to
+	// joinRetryMaxInterval. Joining fails after joinRetryTimeout, if set.
+	// joinTimeout is a hard limit on the whole join, including a request a
+	// peer accepted but never answered.
+	joinRetryInterval    time.Duration
+	joinRetryMaxInterval time.Duration
+	joinRetryTimeout     time.Duration
+	joinTimeout          time.Duration
+
+	// leaderWatchdog is how long the store may be without a leader before
+	// it reports an error on Err. Zero disables the watchdog.
+	leaderWatchdog time.Duration
+
+	// clock is used for timeouts and polling. It is replaced in tests.
+	clock clock
+
+	// applyLatency records the time from submitting each command to raft
+	// until it is committed or fails.
+	applyLatency *histogram
+
+	// logLevel is the minimum level of messages written to Logger.
+	logLevel logLevel
+
+	// config is the configuration the store was created with, as last
+	// changed by Reload.
+	config Config
+
+	// settingsMu guards the settings that Reload changes while the store
+	// is open: logLevel, ApplyTimeout and config.
+	settingsMu sync.RWMutex
 
-type authUser struct {
-	salt []byte
-	hash []byte
+	Logger *log.Logger
 }
 
 // NewStore returns a new instance of Store.
 func NewStore(c *Config) *Store {
+	// Fill in unset fields on a copy so the caller's config is unchanged.
+	cfg := *c
+	cfg.ApplyDefaults()
+	c = &cfg
+
 	s := &Store{
-		path:  c.Dir,
-		peers: c.Peers,
-		data:  &Data{},
+		path:    c.Dir,
+		dirMode: os.FileMode(c.DataDirMode),
+		peers:   c.Peers,
+		data:    &Data{},
 
 		ready:   make(chan struct{}),
 		err:     make(chan error),
 		closing: make(chan struct{}),
-		changed: make(chan struct{}),
+
+		leaderCh:      make(chan string, 1),
+		leaderChanged: make(chan struct{}),
 
 		clusterTracingEnabled: c.ClusterTracing,
 		retentionAutoCreate:   c.RetentionAutoCreate,
@@ -157,11 +247,36 @@ func NewStore(c *Config) *Store {
 		ElectionTimeout:    time.Duration(c.ElectionTimeout),
 		LeaderLeaseTimeout: time.Duration(c.LeaderLeaseTimeout),
 		CommitTimeout:      time.Duration(c.CommitTimeout),
-		authCache:          make(map[string]authUser, 0),
+		ApplyTimeout:       time.Duration(c.ApplyTimeout),
+		SnapshotInterval:   time.Duration(c.RaftSnapshotInterval),
+		SnapshotThreshold:  uint64(c.RaftSnapshotThreshold),
+		TransportTimeout:   time.Duration(c.RaftTransportTimeout),
+		TransportMaxPool:   c.RaftMaxPool,
+		raftStoreType:      c.RaftStoreType,
+		snapshotChecksum:   c.SnapshotChecksum,
+		batchCommands:      c.BatchCommands,
+		authCache:          newAuthCache(c.AuthCacheMaxEntries),
+		authCacheTTL:       time.Duration(c.AuthCacheTTL),
 		hashPassword: func(password string) ([]byte, error) {
-			return bcrypt.GenerateFromPassword([]byte(password), BcryptCost)
+			return bcrypt.GenerateFromPassword([]byte(password), c.HashCost)
 		},
+		joinRetryInterval:    time.Duration(c.JoinRetryInterval),
+		joinRetryMaxInterval: time.Duration(c.JoinRetryMaxInterval),
+		joinRetryTimeout:     time.Duration(c.JoinRetryTimeout),
+		joinTimeout:          time.Duration(c.JoinTimeout),
+		leaderWatchdog:       time.Duration(c.LeaderWatchdog),
+		clock:                realClock{},
+		config:               cfg,
+	}
+
+	buckets := DefaultApplyLatencyBuckets
+	if len(c.ApplyLatencyBuckets) > 0 {
+		buckets = make([]time.Duration, len(c.ApplyLatencyBuckets))
+		for i, d := range c.ApplyLatencyBuckets {
+			buckets[i] = time.Duration(d)
+		}
 	}
+	s.applyLatency = newHistogram(buckets)
 
 	if c.LoggingEnabled {
 		s.Logger = log.New(os.Stderr, "[metastore] ", log.LstdFlags)
@@ -169,15 +284,75 @@ func NewStore(c *Config) *Store {
 		s.Logger = log.New(ioutil.Discard, "", 0)
 	}
 
+	// The config is validated before the store is created; an unknown
+	// level falls back to info.
+	s.logLevel, _ = parseLogLevel(c.LogLevel)
+
 	s.raftState = &localRaft{store: s}
 	s.rpc = &rpc{
 		store:          s,
 		tracingEnabled: c.ClusterTracing,
-		logger:         s.Logger,
+		compression:    c.SnapshotCompression,
+		timeout:        time.Duration(c.RPCTimeout),
+		logLevel:       s.logLevel,
+
+		fetchQueueTimeout: time.Duration(c.SnapshotQueueTimeout),
+	}
+	if c.MaxConcurrentSnapshots > 0 {
+		s.rpc.fetchSlots = make(chan struct{}, c.MaxConcurrentSnapshots)
 	}
 	return s
 }
 
+// logf writes a message to the logger if level is at or above the store's level.
+func (s *Store) logf(level logLevel, format string, v ...interface{}) {
+	s.settingsMu.RLock()
+	min := s.logLevel
+	s.settingsMu.RUnlock()
+
+	if level >= min {
+		s.Logger.Printf(format, v...)
+	}
+}
+
+func (s *Store) infof(format string, v ...interface{})  { s.logf(logLevelInfo, format, v...) }
+func (s *Store) warnf(format string, v ...interface{})  { s.logf(logLevelWarn, format, v...) }
+func (s *Store) errorf(format string, v ...interface{}) { s.logf(logLevelError, format, v...) }
+
+// logger, now and newTimer give the rpc the store's current logger and clock.
+func (s *Store) logger() *log.Logger            { return s.Logger }
+func (s *Store) now() time.Time                 { return s.clock.Now() }
+func (s *Store) newTimer(d time.Duration) timer { return s.clock.NewTimer(d) }
+
+// Reload applies the settings in c that can change while the store is open:
+// the log level, the apply timeout and the rpc timeout. It returns an error
+// and changes nothing if c is invalid or changes any other setting, such as
+// the bind address or the data dir, which need a restart.
+func (s *Store) Reload(c *Config) error {
+	cfg := *c
+	cfg.ApplyDefaults()
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	level, _ := parseLogLevel(cfg.LogLevel)
+
+	s.settingsMu.Lock()
+	if err := checkReload(&s.config, &cfg); err != nil {
+		s.settingsMu.Unlock()
+		return err
+	}
+	s.config = cfg
+	s.logLevel = level
+	s.ApplyTimeout = time.Duration(cfg.ApplyTimeout)
+	s.settingsMu.Unlock()
+
+	s.rpc.reload(level, time.Duration(cfg.RPCTimeout))
+
+	s.infof("Reloaded config: log-level=%s apply-timeout=%v rpc-timeout=%v",
+		cfg.LogLevel, cfg.ApplyTimeout, cfg.RPCTimeout)
+	return nil
+}
+
 // Path returns the root path when open.
 // Returns an empty string when the store is closed.
 func (s *Store) Path() string { return s.path }
@@ -187,6 +362,12 @@ func (s *Store) IDPath() string { return filepath.Join(s.path, "id") }
 
 // Open opens and initializes the raft store.
 func (s *Store) Open() error {
+	return s.OpenContext(context.Background())
+}
+
+// OpenContext opens and initializes the raft store. Joining a cluster and
+// waiting for a leader to be elected are aborted when ctx is cancelled.
+func (s *Store) OpenContext(ctx context.Context) error {
 	// Verify that no more than 3 peers.
 	// https://github.com/influxdb/influxdb/issues/2750
 	if len(s.peers) > MaxRaftNodes {
@@ -202,7 +383,7 @@ func (s *Store) Open() error {
 		panic("Store.RPCListener not set")
 	}
 
-	s.Logger.Printf("Using data dir: %v", s.Path())
+	s.infof("Using data dir: %v", s.Path())
 
 	if err := func() error {
 		s.mu.Lock()
@@ -214,19 +395,32 @@ func (s *Store) Open() error {
 		}
 		s.opened = true
 
+		// Create the root directory if it doesn't already exist.
+		if err := s.createRootDir(); err != nil {
+			return &OpenError{Kind: ErrDataDirUnwritable, Err: err}
+		}
+
+		// Fail now if the directory exists but is read-only, rather than
+		// when raft first writes to it.
+		if err := checkDirWritable(s.path); err != nil {
+			return &OpenError{Kind: ErrDataDirUnwritable, Err: err}
+		}
+
+		// Lock the root directory so another process can't open it.
+		lock, err := lockDir(s.path)
+		if err != nil {
+			return fmt.Errorf("lock: %w", err)
+		}
+		s.lock = lock
+
 		// load our raft state
 		if err := s.loadState(); err != nil {
 			return err
 		}
 
-		// Create the root directory if it doesn't already exist.
-		if err := s.createRootDir(); err != nil {
-			return fmt.Errorf("mkdir all: %s", err)
-		}
-
 		// Open the raft store.
 		if err := s.openRaft(); err != nil {
-			return fmt.Errorf("raft: %s", err)
+			return &OpenError{Kind: ErrRaftOpen, Err: err}
 		}
 
 		// Initialize the store, if necessary.
@@ -236,7 +430,7 @@ func (s *Store) Open() error {
 
 		// Load existing ID, if exists.
 		if err := s.readID(); err != nil {
-			return fmt.Errorf("read id: %s", err)
+			return fmt.Errorf("read id: %w", err)
 		}
 
 		return nil
@@ -251,9 +445,21 @@ func (s *Store) Open() error {
 	s.wg.Add(1)
 	go s.serveRPCListener()
 
+	// Expire cached authentications.
+	if s.authCacheTTL > 0 {
+		s.wg.Add(1)
+		go s.sweepAuthCache()
+	}
+
+	// Report prolonged loss of the leader, if enabled.
+	if s.leaderWatchdog > 0 {
+		s.wg.Add(1)
+		go s.watchLeader()
+	}
+
 	// Join an existing cluster if we needed
-	if err := s.joinCluster(); err != nil {
-		return fmt.Errorf("join: %v", err)
+	if err := s.joinCluster(ctx); err != nil {
+		return &OpenError{Kind: ErrJoinFailed, Err: err}
 	}
 
 	// If the ID doesn't exist then create a new node.
@@ -266,14 +472,18 @@ func (s *Store) Open() error {
 
 	// Wait for a leader to be elected so we know the raft log is loaded
 	// and up to date
-	<-s.ready
-	if err := s.WaitForLeader(0); err != nil {
+	select {
+	case <-s.ready:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if err := s.WaitForLeaderContext(ctx, 0); err != nil {
 		return err
 	}
 
 	if s.raftPromotionEnabled {
 		s.wg.Add(1)
-		s.Logger.Printf("spun up monitoring for %d", s.NodeID())
+		s.infof("spun up monitoring for %d", s.NodeID())
 		go s.monitorPeerHealth()
 	}
 
@@ -301,7 +511,7 @@ func (s *Store) syncNodeInfo() error {
 			}
 
 			if ni.Host == s.RemoteAddr.String() {
-				s.Logger.Printf("Updated node id=%d hostname=%v", s.id, s.RemoteAddr.String())
+				s.infof("Updated node id=%d hostname=%v", s.id, s.RemoteAddr.String())
 				return nil
 			}
 
@@ -326,6 +536,13 @@ func (s *Store) loadState() error {
 		return err
 	}
 
+	// Reject peers that can't be dialed before they are handed to raft.
+	for _, peer := range peers {
+		if err := validatePeerAddr(peer); err != nil {
+			return &InvalidNodeError{Path: filepath.Join(s.path, "peers.json"), Field: "peer", Err: err}
+		}
+	}
+
 	// If we have existing peers, use those.  This will override what's in the
 	// config.
 	if len(peers) > 0 {
@@ -346,7 +563,7 @@ func (s *Store) loadState() error {
 	return nil
 }
 
-func (s *Store) joinCluster() error {
+func (s *Store) joinCluster(ctx context.Context) error {
 
 	// No join options, so nothing to do
 	if len(s.peers) == 0 {
@@ -356,44 +573,154 @@ func (s *Store) joinCluster() error {
 	// We already have a node ID so were already part of a cluster,
 	// don't join again so we can use our existing state.
 	if s.id != 0 {
-		s.Logger.Printf("Skipping cluster join: already member of cluster: nodeId=%v raftEnabled=%v peers=%v",
+		s.infof("Skipping cluster join: already member of cluster: nodeId=%v raftEnabled=%v peers=%v",
 			s.id, raft.PeerContained(s.peers, s.RemoteAddr.String()), s.peers)
 		return nil
 	}
 
-	s.Logger.Printf("Joining cluster at: %v", s.peers)
-	for {
+	s.infof("Joining cluster at: %v", s.peers)
+	// The join timeout is measured with the store's clock, so it cancels ctx
+	// when its timer fires instead of setting a deadline.
+	expired := make(chan struct{})
+	if s.joinTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		defer cancel()
+
+		t := s.clock.NewTimer(s.joinTimeout)
+		defer t.Stop()
+		go func() {
+			select {
+			case <-t.C():
+				close(expired)
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
+	// timedOut is true if the join timeout, rather than the caller, ended ctx.
+	timedOut := func() bool {
+		select {
+		case <-expired:
+			return true
+		default:
+			return false
+		}
+	}
+
+	start := s.clock.Now()
+	var retries int
+	for attempt := 0; ; attempt++ {
+		// The failure of each peer in this round, reported if joining times out.
+		var errs []string
+
 		for _, join := range s.peers {
-			res, err := s.rpc.join(s.RemoteAddr.String(), join)
-			if err != nil {
-				s.Logger.Printf("Join node %v failed: %v: retrying...", join, err)
+			res, err := s.rpc.joinContext(ctx, s.RemoteAddr.String(), join)
+			if err != nil && timedOut() {
+				return fmt.Errorf("unable to join cluster after %v: join request to %s did not complete", s.joinTimeout, join)
+			} else if err != nil {
+				s.warnf("Join node %v failed: %v: retrying...", join, err)
+				errs = append(errs, fmt.Sprintf("%s: %v", join, err))
+				retries++
 				continue
 			}
 
-			s.Logger.Printf("Joined remote node %v", join)
-			s.Logger.Printf("nodeId=%v raftEnabled=%v peers=%v", res.NodeID, res.RaftEnabled, res.RaftNodes)
+			s.mu.Lock()
+			s.joinRetries = retries
+			s.mu.Unlock()
+
+			s.infof("Joined remote node %v", join)
+			s.infof("nodeId=%v raftEnabled=%v peers=%v", res.NodeID, res.RaftEnabled, res.RaftNodes)
 
 			s.peers = res.RaftNodes
 			s.id = res.NodeID
 
 			if err := s.writeNodeID(res.NodeID); err != nil {
-				s.Logger.Printf("Write node id failed: %v", err)
+				s.errorf("Write node id failed: %v", err)
+				errs = append(errs, fmt.Sprintf("%s: write node id: %v", join, err))
 				break
 			}
 
 			if !res.RaftEnabled {
 				// Shutdown our local raft and transition to a remote raft state
 				if err := s.enableRemoteRaft(); err != nil {
-					s.Logger.Printf("Enable remote raft failed: %v", err)
+					s.errorf("Enable remote raft failed: %v", err)
+					errs = append(errs, fmt.Sprintf("%s: enable remote raft: %v", join, err))
 					break
 				}
 			}
 			return nil
 		}
-		time.Sleep(time.Second)
+
+		if s.joinRetryTimeout > 0 && s.clock.Now().Sub(start) >= s.joinRetryTimeout {
+			return fmt.Errorf("unable to join cluster after %v: %s", s.joinRetryTimeout, strings.Join(errs, "; "))
+		}
+
+		timer := s.clock.NewTimer(joinBackoff(attempt, s.joinRetryInterval, s.joinRetryMaxInterval))
+		select {
+		case <-s.closing:
+			timer.Stop()
+			return errors.New("closing")
+		case <-ctx.Done():
+			timer.Stop()
+			if timedOut() {
+				return fmt.Errorf("unable to join cluster after %v: waiting to retry: %s", s.joinTimeout, strings.Join(errs, "; "))
+			}
+			return ctx.Err()
+		case <-timer.C():
+		}
 	}
 }
 
+// ValidateJoin checks that a node could join the cluster through peers
+// without joining it. Each peer must be reachable and serve meta data that
+// this node can decode and that lists at least one node. Nothing is written
+// locally and raft is not started. If any peer fails, a *JoinValidationError
+// reports the failure of each one.
+func (s *Store) ValidateJoin(ctx context.Context, peers []string) error {
+	if len(peers) == 0 {
+		return errors.New("no peers to validate")
+	}
+
+	errs := make(map[string]error)
+	for _, peer := range peers {
+		data, err := s.rpc.fetchMetaDataFrom(ctx, peer)
+		if err == nil && len(data.Nodes) == 0 {
+			err = errors.New("meta data has no nodes")
+		}
+		if err != nil {
+			errs[peer] = err
+		}
+	}
+
+	if len(errs) > 0 {
+		return &JoinValidationError{Peers: peers, Errs: errs}
+	}
+	return nil
+}
+
+// joinBackoff returns the delay before the next join retry. The delay doubles
+// with each attempt from min up to max and is randomized by up to half so
+// that nodes started together do not retry in lockstep.
+func joinBackoff(attempt int, min, max time.Duration) time.Duration {
+	if min <= 0 {
+		min = DefaultJoinRetryInterval
+	}
+	if max < min {
+		max = min
+	}
+
+	d := min
+	for i := 0; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
 func (s *Store) enableLocalRaft() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -401,7 +728,7 @@ func (s *Store) enableLocalRaft() error {
 	if _, ok := s.raftState.(*localRaft); ok {
 		return nil
 	}
-	s.Logger.Printf("Switching to local raft")
+	s.infof("Switching to local raft")
 
 	lr := &localRaft{store: s}
 	return s.changeState(lr)
@@ -412,7 +739,7 @@ func (s *Store) enableRemoteRaft() error {
 		return nil
 	}
 
-	s.Logger.Printf("Switching to remote raft")
+	s.infof("Switching to remote raft")
 	rr := &remoteRaft{store: s}
 	return s.changeState(rr)
 }
@@ -454,7 +781,7 @@ func (s *Store) monitorPeerHealth() {
 			return
 		}
 		if err := s.promoteNodeToPeer(); err != nil {
-			s.Logger.Printf("error promoting node to raft peer: %s", err)
+			s.errorf("error promoting node to raft peer: %s", err)
 		}
 	}
 }
@@ -503,7 +830,7 @@ func (s *Store) promoteNodeToPeer() error {
 	if err := s.rpc.enableRaft(n.Host, peers); err != nil {
 		return fmt.Errorf("error notifying raft peer: %s", err)
 	}
-	s.Logger.Printf("promoted nodeID %d, host %s to raft peer", n.ID, n.Host)
+	s.infof("promoted nodeID %d, host %s to raft peer", n.ID, n.Host)
 
 	return nil
 }
@@ -528,9 +855,7 @@ func (s *Store) Close() error {
 // WaitForDataChanged will block the current goroutine until the metastore index has
 // be updated.
 func (s *Store) WaitForDataChanged() error {
-	s.mu.RLock()
-	changed := s.changed
-	s.mu.RUnlock()
+	_, changed := s.dataIndex()
 
 	for {
 		select {
@@ -542,21 +867,130 @@ func (s *Store) WaitForDataChanged() error {
 	}
 }
 
+// AfterIndexWithValue returns a channel that receives the data index once it
+// is greater than index. If it already is, the value is available
+// immediately. The channel is closed after the value is sent or when the
+// store closes.
+func (s *Store) AfterIndexWithValue(index uint64) <-chan uint64 {
+	ch := make(chan uint64, 1)
+	if current, _ := s.dataIndex(); current > index {
+		ch <- current
+		close(ch)
+		return ch
+	}
+
+	go func() {
+		defer close(ch)
+		if current, err := s.waitForIndex(context.Background(), index); err == nil {
+			ch <- current
+		}
+	}()
+	return ch
+}
+
+// AfterIndexContext returns a channel that is closed once the data index is
+// greater than index, or when ctx is done or the store closes. The goroutine
+// watching the index exits when the channel is closed.
+func (s *Store) AfterIndexContext(ctx context.Context, index uint64) <-chan struct{} {
+	ch := make(chan struct{})
+	if current, _ := s.dataIndex(); current > index {
+		close(ch)
+		return ch
+	}
+
+	go func() {
+		defer close(ch)
+		s.waitForIndex(ctx, index)
+	}()
+	return ch
+}
+
+// waitForIndex blocks until the data index is greater than index and returns it.
+func (s *Store) waitForIndex(ctx context.Context, index uint64) (uint64, error) {
+	for {
+		current, changed := s.dataIndex()
+		if current > index {
+			return current, nil
+		}
+
+		select {
+		case <-s.closing:
+			return 0, ErrStoreClosed
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// WaitForIndex blocks until the data index is at least index, which gives
+// callers that applied a change read-your-writes semantics. It returns
+// ErrLeadershipLost if the store was the leader when called and loses
+// leadership before the index is reached, since the change may never commit.
+func (s *Store) WaitForIndex(ctx context.Context, index uint64) error {
+	wasLeader := s.IsLeader()
+	for {
+		leaderChanged := s.leaderChangedCh()
+		current, changed := s.dataIndex()
+		if current >= index {
+			return nil
+		} else if wasLeader && !s.IsLeader() {
+			return ErrLeadershipLost
+		}
+
+		select {
+		case <-s.closing:
+			return ErrStoreClosed
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		case <-leaderChanged:
+		}
+	}
+}
+
+// leaderChangedCh returns a channel that is closed on the next leadership change.
+func (s *Store) leaderChangedCh() <-chan struct{} {
+	s.leaderMu.Lock()
+	defer s.leaderMu.Unlock()
+	return s.leaderChanged
+}
+
+// dataIndex returns the current data index and a channel that is closed when
+// the data next changes.
+func (s *Store) dataIndex() (uint64, <-chan struct{}) {
+	s.mu.RLock()
+	index, changed := s.data.Index, s.changed
+	s.mu.RUnlock()
+	if changed != nil {
+		return index, changed
+	}
+
+	// No one is waiting yet, so make the channel. The index is read again
+	// with it so that a change in between isn't missed.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.changed == nil {
+		s.changed = make(chan struct{})
+	}
+	return s.data.Index, s.changed
+}
+
 func (s *Store) close() error {
-	// Check if store has already been closed.
+	// Closing a store that is not open is a no-op.
 	if !s.opened {
-		return ErrStoreClosed
+		return nil
 	}
 	s.opened = false
 
 	// Close our exec listener
 	if err := s.ExecListener.Close(); err != nil {
-		s.Logger.Printf("error closing ExecListener %s", err)
+		s.errorf("error closing ExecListener %s", err)
 	}
 
 	// Close our RPC listener
 	if err := s.RPCListener.Close(); err != nil {
-		s.Logger.Printf("error closing ExecListener %s", err)
+		s.errorf("error closing ExecListener %s", err)
 	}
 
 	if s.raftState != nil {
@@ -572,11 +1006,20 @@ func (s *Store) close() error {
 	close(s.closing)
 	s.wg.Wait()
 
+	// Raft has been shut down so no more leader changes will be sent.
+	close(s.leaderCh)
+
 	// Now that all go routines are cleaned up, w lock to do final clean up and exit
 	s.mu.Lock()
 
 	s.raftState = nil
 
+	// Release the root directory now that raft is no longer using it.
+	if s.lock != nil {
+		s.lock.unlock()
+		s.lock = nil
+	}
+
 	return nil
 }
 
@@ -588,17 +1031,41 @@ func (s *Store) readID() error {
 		return nil
 	} else if err != nil {
 		return fmt.Errorf("read file: %s", err)
+	} else if len(b) == 0 {
+		// The file was truncated, such as by a crash while it was written
+		// by an older release. Start as if it were missing; the node is
+		// looked up by host if it is already in the cluster.
+		s.warnf("Node id file %s is empty: creating node again", s.IDPath())
+		s.id = 0
+		s.recoverID = true
+		return nil
 	}
 
 	id, err := strconv.ParseUint(string(b), 10, 64)
 	if err != nil {
-		return fmt.Errorf("parse id: %s", err)
+		return &InvalidNodeError{Path: s.IDPath(), Field: "id", Err: err}
+	} else if id == 0 {
+		// A zero id is never written so the file has been tampered with.
+		return &InvalidNodeError{Path: s.IDPath(), Field: "id", Err: ErrNodeIDRequired}
 	}
 	s.id = id
 
 	return nil
 }
 
+// validatePeerAddr returns an error if addr is not a valid host:port pair.
+func validatePeerAddr(addr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	} else if host == "" {
+		return fmt.Errorf("missing host in address %q", addr)
+	} else if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return fmt.Errorf("invalid port in address %q", addr)
+	}
+	return nil
+}
+
 // init initializes the store in a separate goroutine.
 // This occurs when the store first creates or joins a cluster.
 // The ready channel is closed once the store is initialized.
@@ -621,8 +1088,12 @@ func (s *Store) createLocalNode() error {
 		return fmt.Errorf("wait for leader: %s", err)
 	}
 
-	// Create new node.
+	// Create new node. If the id file was lost then the node may already
+	// exist, in which case its id is reused.
 	ni, err := s.CreateNode(s.RemoteAddr.String())
+	if err == ErrNodeExists && s.recoverID {
+		ni, err = s.NodeByHost(s.RemoteAddr.String())
+	}
 	if err != nil {
 		return fmt.Errorf("create node: %s", err)
 	}
@@ -637,20 +1108,80 @@ func (s *Store) createLocalNode() error {
 	s.id = ni.ID
 	s.mu.Unlock()
 
-	s.Logger.Printf("Created local node: id=%d, host=%s", s.id, s.RemoteAddr)
+	s.infof("Created local node: id=%d, host=%s", s.id, s.RemoteAddr)
 
 	return nil
 }
 
 func (s *Store) createRootDir() error {
-	return os.MkdirAll(s.path, 0777)
+	return os.MkdirAll(s.path, s.dirMode)
 }
 
 func (s *Store) writeNodeID(id uint64) error {
 	if err := s.createRootDir(); err != nil {
 		return err
 	}
-	return ioutil.WriteFile(s.IDPath(), []byte(strconv.FormatUint(id, 10)), 0666)
+	// The id file gets the directory's permissions without the execute bits.
+	return writeFileAtomic(s.IDPath(), []byte(strconv.FormatUint(id, 10)), s.dirMode&^0111)
+}
+
+// checkDirWritable creates and removes a temporary file in dir.
+func checkDirWritable(dir string) error {
+	f, err := ioutil.TempFile(dir, ".write")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// writeFileAtomic writes b to a temporary file in the directory of path,
+// syncs it to disk and then renames it over path. The directory is synced
+// after the rename so the new name survives a crash. A crash or error
+// mid-write leaves the previous contents of path untouched.
+func writeFileAtomic(path string, b []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	f, err := ioutil.TempFile(dir, filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	} else if _, err := f.Write(b); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	} else if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	} else if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return syncDir(dir)
+}
+
+// syncDir syncs the directory at path so entries renamed into it are durable.
+func syncDir(path string) error {
+	d, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	if err := d.Sync(); err != nil {
+		d.Close()
+		return err
+	}
+	return d.Close()
 }
 
 // Snapshot saves a snapshot of the current state.
@@ -658,25 +1189,108 @@ func (s *Store) Snapshot() error {
 	return s.raftState.snapshot()
 }
 
+// ForceSnapshot snapshots the raft log now instead of waiting for the
+// snapshot interval, so raft can compact the log and free disk space. It
+// returns a *NotLeaderError on followers and ErrSnapshotInProgress if an
+// earlier forced snapshot has not finished. Returns ErrStoreClosed if the
+// store is closed.
+func (s *Store) ForceSnapshot() error {
+	// The lock isn't held while the snapshot is taken, since raft takes it
+	// to read the data.
+	s.mu.RLock()
+	state := s.raftState
+	s.mu.RUnlock()
+	if state == nil {
+		return ErrStoreClosed
+	} else if !state.isLeader() {
+		return &NotLeaderError{Leader: state.leader()}
+	}
+
+	s.snapshotMu.Lock()
+	if s.snapshotting {
+		s.snapshotMu.Unlock()
+		return ErrSnapshotInProgress
+	}
+	s.snapshotting = true
+	s.snapshotMu.Unlock()
+
+	defer func() {
+		s.snapshotMu.Lock()
+		s.snapshotting = false
+		s.snapshotMu.Unlock()
+	}()
+
+	s.infof("Forcing raft snapshot")
+	return state.snapshot()
+}
+
+// OnSnapshot registers fn to be called with the data index each time a
+// snapshot has been persisted. fn runs on raft's snapshot goroutine without
+// the store lock held. It should return quickly since raft waits for it.
+func (s *Store) OnSnapshot(fn func(index uint64)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.snapshotFns = append(s.snapshotFns, fn)
+}
+
+// notifySnapshot calls the OnSnapshot callbacks with index.
+func (s *Store) notifySnapshot(index uint64) {
+	s.mu.RLock()
+	fns := s.snapshotFns
+	s.mu.RUnlock()
+
+	for _, fn := range fns {
+		fn(index)
+	}
+}
+
+// SnapshotTo writes a snapshot of the current state to w, compressed with
+// compression, which is one of the SnapshotCompression values. Empty is
+// uncompressed. The data is encoded directly rather than from a clone.
+func (s *Store) SnapshotTo(w io.Writer, compression string) error {
+	s.mu.RLock()
+	b, err := s.data.MarshalBinary()
+	s.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	b, err = compressData(b, compression)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(b)
+	return err
+}
+
 // WaitForLeader sleeps until a leader is found or a timeout occurs.
 // timeout == 0 means to wait forever.
 func (s *Store) WaitForLeader(timeout time.Duration) error {
+	return s.WaitForLeaderContext(context.Background(), timeout)
+}
+
+// WaitForLeaderContext is like WaitForLeader but also returns ctx.Err()
+// if ctx is cancelled before a leader is found.
+func (s *Store) WaitForLeaderContext(ctx context.Context, timeout time.Duration) error {
 	// Begin timeout timer.
-	timer := time.NewTimer(timeout)
+	timer := s.clock.NewTimer(timeout)
 	defer timer.Stop()
 
 	// Continually check for leader until timeout.
-	ticker := time.NewTicker(100 * time.Millisecond)
+	ticker := s.clock.NewTicker(100 * time.Millisecond)
 	defer ticker.Stop()
 	for {
 		select {
 		case <-s.closing:
 			return errors.New("closing")
-		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C():
 			if timeout != 0 {
 				return errors.New("timeout")
 			}
-		case <-ticker.C:
+		case <-ticker.C():
 			if s.Leader() != "" {
 				return nil
 			}
@@ -687,14 +1301,27 @@ func (s *Store) WaitForLeader(timeout time.Duration) error {
 // Ready returns a channel that is closed once the store is initialized.
 func (s *Store) Ready() <-chan struct{} { return s.ready }
 
+// LeaderCh returns a channel that receives the address of the leader
+// whenever this node gains or loses leadership. Only the most recent change
+// is kept if the receiver falls behind. The channel is closed when the
+// store is closed.
+func (s *Store) LeaderCh() <-chan string { return s.leaderCh }
+
 // Err returns a channel for all out-of-band errors.
 func (s *Store) Err() <-chan error { return s.err }
 
-// IsLeader returns true if the store is currently the leader.
+// IsOpen returns true if the store has been opened and not yet closed.
+func (s *Store) IsOpen() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.opened
+}
+
+// IsLeader returns true if the store is open and currently the leader.
 func (s *Store) IsLeader() bool {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	return s.raftState.isLeader()
+	return s.raftState != nil && s.raftState.isLeader()
 }
 
 // IsLocal returns true if the store is currently participating in local raft.
@@ -715,6 +1342,60 @@ func (s *Store) Leader() string {
 	return s.raftState.leader()
 }
 
+// LastAppliedIndex returns the last raft log index applied by the store. It
+// can run ahead of the data index while the FSM catches up.
+func (s *Store) LastAppliedIndex() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.raftState == nil {
+		return 0
+	}
+	return s.raftState.appliedIndex()
+}
+
+// Statistics returns the raft statistics merged with the index and term of
+// the store's data, the current leader and the auth cache counters. Raft
+// statistics are only included when the store is open and running a local
+// raft. Auth cache counters only increase from the time the store is created.
+// The raft applied_index statistic is the same as LastAppliedIndex.
+//
+// The fetch_data stats describe the last meta data fetched from the leader:
+// its size as sent and how long the request took. For blocking fetches the
+// duration includes the wait for a change. join_retries is the number of
+// failed join requests before the node last joined a cluster.
+//
+// The apply_duration stats are a histogram of how long commands applied on
+// this node took to commit. apply_duration_le_<bound> is the number that
+// took at most bound, and apply_duration_count and apply_duration_sum are
+// the number of commands and their total duration.
+func (s *Store) Statistics() map[string]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stats := make(map[string]string)
+	if s.raftState != nil {
+		for k, v := range s.raftState.stats() {
+			stats[k] = v
+		}
+		stats["leader"] = s.raftState.leader()
+	} else {
+		stats["leader"] = ""
+	}
+	stats["data_index"] = strconv.FormatUint(s.data.Index, 10)
+	stats["data_term"] = strconv.FormatUint(s.data.Term, 10)
+	stats["auth_cache_hits"] = strconv.FormatUint(s.authCache.hits, 10)
+	stats["auth_cache_misses"] = strconv.FormatUint(s.authCache.misses, 10)
+	stats["auth_cache_evictions"] = strconv.FormatUint(s.authCache.evictions, 10)
+
+	fs := s.rpc.lastFetchStats()
+	stats["fetch_data_bytes"] = strconv.Itoa(fs.bytes)
+	stats["fetch_data_duration"] = fs.duration.String()
+	stats["fetch_data_blocking"] = strconv.FormatBool(fs.blocking)
+	stats["join_retries"] = strconv.Itoa(s.joinRetries)
+	s.applyLatency.stats("apply_duration", stats)
+	return stats
+}
+
 // SetPeers sets a list of peers in the cluster.
 func (s *Store) SetPeers(addrs []string) error {
 	return s.raftState.setPeers(addrs)
@@ -726,9 +1407,13 @@ func (s *Store) AddPeer(addr string) error {
 }
 
 // Peers returns the list of peers in the cluster.
+// Returns ErrStoreClosed if the store is closed.
 func (s *Store) Peers() ([]string, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
+	if s.raftState == nil {
+		return nil, ErrStoreClosed
+	}
 	return s.raftState.peers()
 }
 
@@ -742,10 +1427,10 @@ func (s *Store) serveExecListener() {
 		var err error
 		conn, err := s.ExecListener.Accept()
 		if opErr, ok := err.(*net.OpError); ok && opErr.Temporary() {
-			s.Logger.Printf("exec listener temporary accept error: %s", err)
+			s.warnf("exec listener temporary accept error: %s", err)
 			continue
 		} else if err != nil {
-			s.Logger.Printf("exec listener accept error and closed: %s", err)
+			s.infof("exec listener accept error and closed: %s", err)
 			return
 		}
 
@@ -771,20 +1456,20 @@ func (s *Store) handleExecConn(conn net.Conn) {
 	if !s.IsLeader() {
 
 		if s.Leader() == s.RemoteAddr.String() {
-			s.Logger.Printf("No leader")
+			s.warnf("No leader")
 			return
 		}
 
 		leaderConn, err := net.DialTimeout("tcp", s.Leader(), 10*time.Second)
 		if err != nil {
-			s.Logger.Printf("Dial leader: %v", err)
+			s.errorf("Dial leader: %v", err)
 			return
 		}
 		defer leaderConn.Close()
 		leaderConn.Write([]byte{MuxExecHeader})
 
 		if err := proxy(leaderConn.(*net.TCPConn), conn.(*net.TCPConn)); err != nil {
-			s.Logger.Printf("Leader proxy error: %v", err)
+			s.errorf("Leader proxy error: %v", err)
 		}
 		conn.Close()
 		return
@@ -836,9 +1521,9 @@ func (s *Store) handleExecConn(conn net.Conn) {
 	if b, err := proto.Marshal(&resp); err != nil {
 		panic(err)
 	} else if err = binary.Write(conn, binary.BigEndian, uint64(len(b))); err != nil {
-		s.Logger.Printf("Unable to write exec response size: %s", err)
+		s.errorf("Unable to write exec response size: %s", err)
 	} else if _, err = conn.Write(b); err != nil {
-		s.Logger.Printf("Unable to write exec response: %s", err)
+		s.errorf("Unable to write exec response: %s", err)
 	}
 	conn.Close()
 }
@@ -852,10 +1537,10 @@ func (s *Store) serveRPCListener() {
 		// Accept next TCP connection.
 		conn, err := s.RPCListener.Accept()
 		if opErr, ok := err.(*net.OpError); ok && opErr.Temporary() {
-			s.Logger.Printf("RPC listener temporary accept error: %s", err)
+			s.warnf("RPC listener temporary accept error: %s", err)
 			continue
 		} else if err != nil {
-			s.Logger.Printf("RPC listener accept error and closed: %s", err)
+			s.infof("RPC listener accept error and closed: %s", err)
 			return
 		}
 
@@ -876,9 +1561,11 @@ func (s *Store) serveRPCListener() {
 
 // MarshalBinary encodes the store's data to a binary protobuf format.
 func (s *Store) MarshalBinary() ([]byte, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	return s.data.MarshalBinary()
+	var buf bytes.Buffer
+	if err := s.SnapshotTo(&buf, SnapshotCompressionNone); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
 }
 
 // ClusterID returns the unique identifier for the cluster.
@@ -1001,6 +1688,19 @@ func (s *Store) Databases() (dis []DatabaseInfo, err error) {
 	return
 }
 
+// DatabaseNames returns the names of all databases. Only the names are
+// copied, so it is cheaper than Databases when nothing else is needed.
+func (s *Store) DatabaseNames() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	names := make([]string, len(s.data.Databases))
+	for i := range s.data.Databases {
+		names[i] = s.data.Databases[i].Name
+	}
+	return names
+}
+
 // CreateDatabase creates a new database in the store.
 func (s *Store) CreateDatabase(name string) (*DatabaseInfo, error) {
 	if err := s.exec(internal.Command_CreateDatabaseCommand, internal.E_CreateDatabaseCommand_Command,
@@ -1010,7 +1710,7 @@ func (s *Store) CreateDatabase(name string) (*DatabaseInfo, error) {
 	); err != nil {
 		return nil, err
 	}
-	s.Logger.Printf("database '%s' created", name)
+	s.infof("database '%s' created", name)
 
 	if s.retentionAutoCreate {
 		// Read node count.
@@ -1053,7 +1753,7 @@ func (s *Store) CreateDatabaseWithRetentionPolicy(name string, rpi *RetentionPol
 	); err != nil {
 		return nil, err
 	}
-	s.Logger.Printf("database '%s' created", name)
+	s.infof("database '%s' created", name)
 
 	if _, err := s.CreateRetentionPolicy(name, rpi); err != nil {
 		return nil, err
@@ -1153,7 +1853,7 @@ func (s *Store) CreateRetentionPolicy(database string, rpi *RetentionPolicyInfo)
 		return nil, err
 	}
 
-	s.Logger.Printf("retention policy '%s' for database '%s' created", rpi.Name, database)
+	s.infof("retention policy '%s' for database '%s' created", rpi.Name, database)
 	return s.RetentionPolicy(database, rpi.Name)
 }
 
@@ -1186,6 +1886,10 @@ func (s *Store) SetDefaultRetentionPolicy(database, name string) error {
 
 // UpdateRetentionPolicy updates an existing retention policy.
 func (s *Store) UpdateRetentionPolicy(database, name string, rpu *RetentionPolicyUpdate) error {
+	if err := rpu.Validate(); err != nil {
+		return err
+	}
+
 	var newName *string
 	if rpu.Name != nil {
 		newName = rpu.Name
@@ -1203,13 +1907,20 @@ func (s *Store) UpdateRetentionPolicy(database, name string, rpu *RetentionPolic
 		replicaN = &value
 	}
 
+	var shardGroupDuration *int64
+	if rpu.ShardGroupDuration != nil {
+		value := int64(*rpu.ShardGroupDuration)
+		shardGroupDuration = &value
+	}
+
 	return s.exec(internal.Command_UpdateRetentionPolicyCommand, internal.E_UpdateRetentionPolicyCommand_Command,
 		&internal.UpdateRetentionPolicyCommand{
-			Database: proto.String(database),
-			Name:     proto.String(name),
-			NewName:  newName,
-			Duration: duration,
-			ReplicaN: replicaN,
+			Database:           proto.String(database),
+			Name:               proto.String(name),
+			NewName:            newName,
+			Duration:           duration,
+			ReplicaN:           replicaN,
+			ShardGroupDuration: shardGroupDuration,
 		},
 	)
 }
@@ -1413,10 +2124,15 @@ func (s *Store) User(name string) (ui *UserInfo, err error) {
 	return
 }
 
-// Users returns a list of all users.
+// Users returns a copy of all users with their password hashes removed, so
+// the list can be served or logged safely.
 func (s *Store) Users() (a []UserInfo, err error) {
 	err = s.read(func(data *Data) error {
-		a = data.Users
+		a = make([]UserInfo, len(data.Users))
+		for i := range data.Users {
+			a[i] = data.Users[i].clone()
+			a[i].Hash = ""
+		}
 		return nil
 	})
 	return
@@ -1452,7 +2168,15 @@ func (s *Store) Authenticate(username, password string) (ui *UserInfo, err error
 		}
 
 		// Check the local auth cache first.
-		if au, ok := s.authCache[username]; ok {
+		au, ok := s.authCache.get(username)
+		if ok && au.expired(s.clock.Now()) {
+			s.authCache.remove(username)
+			s.authCache.evictions++
+			ok = false
+		}
+		if ok {
+			s.authCache.hits++
+
 			// verify the password using the cached salt and hash
 			hashed, err := s.hashWithSalt(au.salt, password)
 			if err != nil {
@@ -1465,6 +2189,7 @@ func (s *Store) Authenticate(username, password string) (ui *UserInfo, err error
 			}
 			return ErrAuthenticate
 		}
+		s.authCache.misses++
 
 		// Compare password with user hash.
 		if err := bcrypt.CompareHashAndPassword([]byte(u.Hash), []byte(password)); err != nil {
@@ -1476,7 +2201,11 @@ func (s *Store) Authenticate(username, password string) (ui *UserInfo, err error
 		if err != nil {
 			return err
 		}
-		s.authCache[username] = authUser{salt: salt, hash: hashed}
+		au = authUser{salt: salt, hash: hashed}
+		if s.authCacheTTL > 0 {
+			au.expires = s.clock.Now().Add(s.authCacheTTL)
+		}
+		s.authCache.set(username, au)
 
 		ui = u
 		return nil
@@ -1484,6 +2213,69 @@ func (s *Store) Authenticate(username, password string) (ui *UserInfo, err error
 	return
 }
 
+// sweepAuthCache periodically removes expired entries from the auth cache.
+func (s *Store) sweepAuthCache() {
+	defer s.wg.Done()
+
+	ticker := s.clock.NewTicker(s.authCacheTTL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.closing:
+			return
+		case <-ticker.C():
+			s.mu.Lock()
+			s.authCache.removeExpired(s.clock.Now())
+			s.mu.Unlock()
+		}
+	}
+}
+
+// watchLeader logs an error and sends it on Err each time the store has
+// been without a leader for the watchdog duration. The leader is checked
+// once per duration, so loss of the leader is reported within twice the
+// duration. The watchdog only reports; recovering quorum is left to the
+// operator since forcing a new peer set can lose committed changes.
+func (s *Store) watchLeader() {
+	defer s.wg.Done()
+
+	var since time.Time // when the leader was first found missing
+	if s.Leader() == "" {
+		since = s.clock.Now()
+	}
+
+	ticker := s.clock.NewTicker(s.leaderWatchdog)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.closing:
+			return
+		case <-ticker.C():
+		}
+
+		now := s.clock.Now()
+		if s.Leader() != "" {
+			since = time.Time{}
+			continue
+		} else if since.IsZero() {
+			since = now
+			continue
+		} else if now.Sub(since) < s.leaderWatchdog {
+			continue
+		}
+
+		err := fmt.Errorf("no raft leader for %v: check that a quorum of raft peers is reachable", now.Sub(since))
+		s.errorf("Leader watchdog: %v", err)
+		since = now
+
+		select {
+		case s.err <- err:
+		case <-s.closing:
+			return
+		}
+	}
+}
+
 // hashWithSalt returns a salted hash of password using salt
 func (s *Store) hashWithSalt(salt []byte, password string) ([]byte, error) {
 	hasher := sha256.New()
@@ -1619,10 +2411,10 @@ func (s *Store) PrecreateShardGroups(from, to time.Time) error {
 					// Create successive shard group.
 					nextShardGroupTime := g.EndTime.Add(1 * time.Nanosecond)
 					if newGroup, err := s.CreateShardGroupIfNotExists(di.Name, rp.Name, nextShardGroupTime); err != nil {
-						s.Logger.Printf("failed to precreate successive shard group for group %d: %s",
+						s.errorf("failed to precreate successive shard group for group %d: %s",
 							g.ID, err.Error())
 					} else {
-						s.Logger.Printf("new shard group %d successfully precreated for database %s, retention policy %s",
+						s.infof("new shard group %d successfully precreated for database %s, retention policy %s",
 							newGroup.ID, di.Name, rp.Name)
 					}
 				}
@@ -1643,6 +2435,51 @@ func (s *Store) SetData(data *Data) error {
 	)
 }
 
+// Restore replaces the store's data with a snapshot and commits it through
+// raft so followers converge on it. Unless force is set, it returns
+// ErrStoreNotEmpty if the store holds any databases or users and
+// ErrStoreInCluster if the store has other raft peers.
+func (s *Store) Restore(data *Data, force bool) error {
+	if !force {
+		if cur := s.cachedData(); len(cur.Databases) > 0 || len(cur.Users) > 0 {
+			return ErrStoreNotEmpty
+		}
+
+		peers, err := s.Peers()
+		if err != nil {
+			return err
+		} else if len(peers) > 1 {
+			return ErrStoreInCluster
+		}
+	}
+	return s.SetData(data)
+}
+
+// ReadConsistent returns a copy of the meta data that reflects every change
+// committed before the call. The leader confirms its leadership with a
+// quorum first. Other nodes fetch the latest data from the leader and return
+// an error naming the leader if that fails. Returns ErrStoreClosed if the
+// store is closed.
+func (s *Store) ReadConsistent() (*Data, error) {
+	// The lock isn't held while waiting on raft or the leader, since
+	// updating the data from the leader takes it.
+	s.mu.RLock()
+	state := s.raftState
+	s.mu.RUnlock()
+	if state == nil {
+		return nil, ErrStoreClosed
+	}
+
+	if state.isLeader() {
+		if err := state.verifyLeader(); err != nil {
+			return nil, err
+		}
+	} else if err := state.invalidate(); err != nil {
+		return nil, fmt.Errorf("consistent read from leader %q: %s", state.leader(), err)
+	}
+	return s.cachedData().Clone(), nil
+}
+
 // read executes a function with the current metadata.
 // If an error is returned then the cache is invalidated and retried.
 //
@@ -1705,9 +2542,84 @@ func (s *Store) exec(typ internal.Command_Type, desc *proto.ExtensionDesc, value
 	return s.remoteExec(b)
 }
 
-// apply applies a serialized command to the raft log.
+// ApplyBatch applies encoded commands as a single raft log entry. The
+// commands take effect in order and either all are applied or, if one
+// fails, none are. Each command is checked before the batch is committed
+// and an error wrapping ErrInvalidCommand is returned for the first that
+// can't be decoded or batched.
+//
+// Nodes running a release without batch support can't apply the log entry
+// and stop when they replicate it. ApplyBatch therefore returns
+// ErrBatchDisabled unless batch-commands is enabled, which must only be done
+// once every node in the cluster has been upgraded.
+func (s *Store) ApplyBatch(cmds [][]byte) error {
+	if !s.batchCommands {
+		return ErrBatchDisabled
+	}
+	for i, b := range cmds {
+		if err := validateBatchCommand(b); err != nil {
+			return fmt.Errorf("batch command %d: %w", i, err)
+		}
+	}
+	return s.exec(internal.Command_BatchCommand, internal.E_BatchCommand_Command,
+		&internal.BatchCommand{Commands: cmds},
+	)
+}
+
+// validateBatchCommand returns an error if b is not a command that can be
+// applied in a batch. Commands with effects outside the meta data, such as
+// removing a raft peer, and nested batches are rejected.
+func validateBatchCommand(b []byte) error {
+	var cmd internal.Command
+	if err := proto.Unmarshal(b, &cmd); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidCommand, err)
+	}
+
+	typ := cmd.GetType()
+	switch typ {
+	case internal.Command_RemovePeerCommand, internal.Command_BatchCommand:
+		return fmt.Errorf("%w: %s can't be batched", ErrInvalidCommand, typ)
+	}
+
+	// Each command type carries its value in the extension named after it.
+	name := "internal." + typ.String() + ".command"
+	for _, desc := range proto.RegisteredExtensions(&cmd) {
+		if desc.Name != name {
+			continue
+		} else if !proto.HasExtension(&cmd, desc) {
+			break
+		} else if _, err := proto.GetExtension(&cmd, desc); err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidCommand, err)
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: missing %s", ErrInvalidCommand, typ)
+}
+
+// apply applies a serialized command to the raft log within the store's
+// apply timeout.
 func (s *Store) apply(b []byte) error {
-	return s.raftState.apply(b)
+	s.settingsMu.RLock()
+	timeout := s.ApplyTimeout
+	s.settingsMu.RUnlock()
+
+	return s.applyTimeout(b, timeout)
+}
+
+// applyTimeout applies a serialized command to the raft log. It returns
+// ErrApplyTimeout if the command is not committed within timeout and
+// ErrLeadershipLost if the store stops being the leader first.
+// A zero timeout waits until the command is committed. Returns a
+// *NotLeaderError if the store is not the leader.
+func (s *Store) applyTimeout(b []byte, timeout time.Duration) error {
+	if !s.raftState.isLeader() {
+		return &NotLeaderError{Leader: s.raftState.leader()}
+	}
+
+	start := s.clock.Now()
+	err := s.raftState.apply(b, timeout)
+	s.applyLatency.observe(s.clock.Now().Sub(start))
+	return err
 }
 
 // remoteExec sends an encoded command to the remote leader.
@@ -1775,16 +2687,16 @@ func (s *Store) sync(index uint64, timeout time.Duration) error {
 	return s.raftState.sync(index, timeout)
 }
 
+// cachedData returns the current meta data without copying it. Commands
+// replace the data rather than change it, so the returned data is never
+// modified by the store. Callers must not modify it either; use Clone for
+// a copy that can be changed.
 func (s *Store) cachedData() *Data {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	return s.data.Clone()
+	return s.data
 }
 
-// BcryptCost is the cost associated with generating password with Bcrypt.
-// This setting is lowered during testing to improve test suite performance.
-var BcryptCost = 10
-
 // HashPasswordFn represnets a password hashing function.
 type HashPasswordFn func(password string) ([]byte, error)
 
@@ -1804,10 +2716,37 @@ func (s *Store) SetHashPasswordFn(fn HashPasswordFn) {
 
 // notifiyChanged will close a changed channel which brooadcasts to all waiting
 // goroutines that the meta store has been updated.  Callers are responsible for locking
-// the meta store before calling this.
+// the meta store before calling this. The next waiter makes a new channel.
 func (s *Store) notifyChanged() {
-	close(s.changed)
-	s.changed = make(chan struct{})
+	if s.changed != nil {
+		close(s.changed)
+		s.changed = nil
+	}
+}
+
+// notifyLeaderChanged sends leader on the leader channel. A pending value that
+// has not been received is replaced so that slow receivers never block raft.
+func (s *Store) notifyLeaderChanged(leader string) {
+	s.leaderMu.Lock()
+	close(s.leaderChanged)
+	s.leaderChanged = make(chan struct{})
+	s.leaderMu.Unlock()
+
+	select {
+	case s.leaderCh <- leader:
+		return
+	default:
+	}
+
+	// Drop the stale value and try again.
+	select {
+	case <-s.leaderCh:
+	default:
+	}
+	select {
+	case s.leaderCh <- leader:
+	default:
+	}
 }
 
 // storeFSM represents the finite state machine used by Store to interact with Raft.
@@ -1824,68 +2763,77 @@ func (fsm *storeFSM) Apply(l *raft.Log) interface{} {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	err := func() interface{} {
-		switch cmd.GetType() {
-		case internal.Command_RemovePeerCommand:
-			return fsm.applyRemovePeerCommand(&cmd)
-		case internal.Command_CreateNodeCommand:
-			return fsm.applyCreateNodeCommand(&cmd)
-		case internal.Command_DeleteNodeCommand:
-			return fsm.applyDeleteNodeCommand(&cmd)
-		case internal.Command_CreateDatabaseCommand:
-			return fsm.applyCreateDatabaseCommand(&cmd)
-		case internal.Command_DropDatabaseCommand:
-			return fsm.applyDropDatabaseCommand(&cmd)
-		case internal.Command_CreateRetentionPolicyCommand:
-			return fsm.applyCreateRetentionPolicyCommand(&cmd)
-		case internal.Command_DropRetentionPolicyCommand:
-			return fsm.applyDropRetentionPolicyCommand(&cmd)
-		case internal.Command_SetDefaultRetentionPolicyCommand:
-			return fsm.applySetDefaultRetentionPolicyCommand(&cmd)
-		case internal.Command_UpdateRetentionPolicyCommand:
-			return fsm.applyUpdateRetentionPolicyCommand(&cmd)
-		case internal.Command_CreateShardGroupCommand:
-			return fsm.applyCreateShardGroupCommand(&cmd)
-		case internal.Command_DeleteShardGroupCommand:
-			return fsm.applyDeleteShardGroupCommand(&cmd)
-		case internal.Command_CreateContinuousQueryCommand:
-			return fsm.applyCreateContinuousQueryCommand(&cmd)
-		case internal.Command_DropContinuousQueryCommand:
-			return fsm.applyDropContinuousQueryCommand(&cmd)
-		case internal.Command_CreateSubscriptionCommand:
-			return fsm.applyCreateSubscriptionCommand(&cmd)
-		case internal.Command_DropSubscriptionCommand:
-			return fsm.applyDropSubscriptionCommand(&cmd)
-		case internal.Command_CreateUserCommand:
-			return fsm.applyCreateUserCommand(&cmd)
-		case internal.Command_DropUserCommand:
-			return fsm.applyDropUserCommand(&cmd)
-		case internal.Command_UpdateUserCommand:
-			return fsm.applyUpdateUserCommand(&cmd)
-		case internal.Command_SetPrivilegeCommand:
-			return fsm.applySetPrivilegeCommand(&cmd)
-		case internal.Command_SetAdminPrivilegeCommand:
-			return fsm.applySetAdminPrivilegeCommand(&cmd)
-		case internal.Command_SetDataCommand:
-			return fsm.applySetDataCommand(&cmd)
-		case internal.Command_UpdateNodeCommand:
-			return fsm.applyUpdateNodeCommand(&cmd)
-		default:
-			panic(fmt.Errorf("cannot apply command: %x", l.Data))
-		}
-	}()
+	err := fsm.applyCommand(&cmd, l.Data)
 
-	// Copy term and index to new metadata.
-	fsm.data.Term = l.Term
-	fsm.data.Index = l.Index
+	// Copy term and index to new metadata. The data may be shared with
+	// readers and snapshots, so it is copied rather than updated in place.
+	other := *fsm.data
+	other.Term = l.Term
+	other.Index = l.Index
+	fsm.data = &other
 	s.notifyChanged()
 
 	return err
 }
 
+// applyCommand applies a decoded command, encoded as b, to the store.
+func (fsm *storeFSM) applyCommand(cmd *internal.Command, b []byte) interface{} {
+	switch cmd.GetType() {
+	case internal.Command_RemovePeerCommand:
+		return fsm.applyRemovePeerCommand(cmd)
+	case internal.Command_CreateNodeCommand:
+		return fsm.applyCreateNodeCommand(cmd)
+	case internal.Command_DeleteNodeCommand:
+		return fsm.applyDeleteNodeCommand(cmd)
+	case internal.Command_CreateDatabaseCommand:
+		return fsm.applyCreateDatabaseCommand(cmd)
+	case internal.Command_DropDatabaseCommand:
+		return fsm.applyDropDatabaseCommand(cmd)
+	case internal.Command_CreateRetentionPolicyCommand:
+		return fsm.applyCreateRetentionPolicyCommand(cmd)
+	case internal.Command_DropRetentionPolicyCommand:
+		return fsm.applyDropRetentionPolicyCommand(cmd)
+	case internal.Command_SetDefaultRetentionPolicyCommand:
+		return fsm.applySetDefaultRetentionPolicyCommand(cmd)
+	case internal.Command_UpdateRetentionPolicyCommand:
+		return fsm.applyUpdateRetentionPolicyCommand(cmd)
+	case internal.Command_CreateShardGroupCommand:
+		return fsm.applyCreateShardGroupCommand(cmd)
+	case internal.Command_DeleteShardGroupCommand:
+		return fsm.applyDeleteShardGroupCommand(cmd)
+	case internal.Command_CreateContinuousQueryCommand:
+		return fsm.applyCreateContinuousQueryCommand(cmd)
+	case internal.Command_DropContinuousQueryCommand:
+		return fsm.applyDropContinuousQueryCommand(cmd)
+	case internal.Command_CreateSubscriptionCommand:
+		return fsm.applyCreateSubscriptionCommand(cmd)
+	case internal.Command_DropSubscriptionCommand:
+		return fsm.applyDropSubscriptionCommand(cmd)
+	case internal.Command_CreateUserCommand:
+		return fsm.applyCreateUserCommand(cmd)
+	case internal.Command_DropUserCommand:
+		return fsm.applyDropUserCommand(cmd)
+	case internal.Command_UpdateUserCommand:
+		return fsm.applyUpdateUserCommand(cmd)
+	case internal.Command_SetPrivilegeCommand:
+		return fsm.applySetPrivilegeCommand(cmd)
+	case internal.Command_SetAdminPrivilegeCommand:
+		return fsm.applySetAdminPrivilegeCommand(cmd)
+	case internal.Command_SetDataCommand:
+		return fsm.applySetDataCommand(cmd)
+	case internal.Command_UpdateNodeCommand:
+		return fsm.applyUpdateNodeCommand(cmd)
+	case internal.Command_BatchCommand:
+		return fsm.applyBatchCommand(cmd)
+	default:
+		panic(fmt.Errorf("cannot apply command: %x", b))
+	}
+}
+
 func (fsm *storeFSM) applyRemovePeerCommand(cmd *internal.Command) interface{} {
 	ext, _ := proto.GetExtension(cmd, internal.E_RemovePeerCommand_Command)
 	v := ext.(*internal.RemovePeerCommand)
+	s := (*Store)(fsm)
 
 	id := v.GetID()
 	addr := v.GetAddr()
@@ -1893,17 +2841,17 @@ func (fsm *storeFSM) applyRemovePeerCommand(cmd *internal.Command) interface{} {
 	// Only do this if you are the leader
 	if fsm.raftState.isLeader() {
 		//Remove that node from the peer
-		fsm.Logger.Printf("removing peer for node id %d, %s", id, addr)
+		s.infof("removing peer for node id %d, %s", id, addr)
 		if err := fsm.raftState.removePeer(addr); err != nil {
-			fsm.Logger.Printf("error removing peer: %s", err)
+			s.errorf("error removing peer: %s", err)
 		}
 	}
 
 	// If this is the node being shutdown, close raft
 	if fsm.id == id {
-		fsm.Logger.Printf("shutting down raft for %s", addr)
+		s.infof("shutting down raft for %s", addr)
 		if err := fsm.raftState.close(); err != nil {
-			fsm.Logger.Printf("failed to shut down raft: %s", err)
+			s.errorf("failed to shut down raft: %s", err)
 		}
 	}
 
@@ -1946,6 +2894,26 @@ func (fsm *storeFSM) applyUpdateNodeCommand(cmd *internal.Command) interface{} {
 	return nil
 }
 
+func (fsm *storeFSM) applyBatchCommand(cmd *internal.Command) interface{} {
+	ext, _ := proto.GetExtension(cmd, internal.E_BatchCommand_Command)
+	v := ext.(*internal.BatchCommand)
+
+	// Every command replaces fsm.data with an updated copy, so restoring
+	// the original undoes the earlier commands if a later one fails.
+	data := fsm.data
+	for i, b := range v.GetCommands() {
+		var other internal.Command
+		if err := proto.Unmarshal(b, &other); err != nil {
+			panic(fmt.Errorf("cannot unmarshal batch command: %x", b))
+		}
+		if err, ok := fsm.applyCommand(&other, b).(error); ok {
+			fsm.data = data
+			return fmt.Errorf("batch command %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
 func (fsm *storeFSM) applyDeleteNodeCommand(cmd *internal.Command) interface{} {
 	ext, _ := proto.GetExtension(cmd, internal.E_DeleteNodeCommand_Command)
 	v := ext.(*internal.DeleteNodeCommand)
@@ -1958,7 +2926,7 @@ func (fsm *storeFSM) applyDeleteNodeCommand(cmd *internal.Command) interface{} {
 	fsm.data = other
 
 	id := v.GetID()
-	fsm.Logger.Printf("node '%d' removed", id)
+	(*Store)(fsm).infof("node '%d' removed", id)
 
 	return nil
 }
@@ -2054,6 +3022,10 @@ func (fsm *storeFSM) applyUpdateRetentionPolicyCommand(cmd *internal.Command) in
 		value := int(v.GetReplicaN())
 		rpu.ReplicaN = &value
 	}
+	if v.ShardGroupDuration != nil {
+		value := time.Duration(v.GetShardGroupDuration())
+		rpu.ShardGroupDuration = &value
+	}
 
 	// Copy data and update.
 	other := fsm.data.Clone()
@@ -2173,7 +3145,7 @@ func (fsm *storeFSM) applyDropUserCommand(cmd *internal.Command) interface{} {
 		return err
 	}
 	fsm.data = other
-	delete(fsm.authCache, v.GetName())
+	fsm.authCache.remove(v.GetName())
 	return nil
 }
 
@@ -2187,7 +3159,7 @@ func (fsm *storeFSM) applyUpdateUserCommand(cmd *internal.Command) interface{} {
 		return err
 	}
 	fsm.data = other
-	delete(fsm.authCache, v.GetName())
+	fsm.authCache.remove(v.GetName())
 	return nil
 }
 
@@ -2233,7 +3205,7 @@ func (fsm *storeFSM) Snapshot() (raft.FSMSnapshot, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	return &storeFSMSnapshot{Data: (*Store)(fsm).data}, nil
+	return &storeFSMSnapshot{Data: s.data, store: s}, nil
 }
 
 func (fsm *storeFSM) Restore(r io.ReadCloser) error {
@@ -2243,6 +3215,16 @@ func (fsm *storeFSM) Restore(r io.ReadCloser) error {
 		return err
 	}
 
+	// Verify the checksum. Snapshots are only written with one when
+	// snapshot-checksum is enabled, so a missing checksum is only worth a
+	// warning when this node expects one.
+	b, checked, err := decodeSnapshot(b)
+	if err != nil {
+		return err
+	} else if !checked && fsm.snapshotChecksum {
+		(*Store)(fsm).warnf("Restoring snapshot without checksum although snapshot-checksum is enabled")
+	}
+
 	// Decode metadata.
 	data := &Data{}
 	if err := data.UnmarshalBinary(b); err != nil {
@@ -2258,7 +3240,8 @@ func (fsm *storeFSM) Restore(r io.ReadCloser) error {
 }
 
 type storeFSMSnapshot struct {
-	Data *Data
+	Data  *Data
+	store *Store // notified once the snapshot is persisted
 }
 
 func (s *storeFSMSnapshot) Persist(sink raft.SnapshotSink) error {
@@ -2269,6 +3252,11 @@ func (s *storeFSMSnapshot) Persist(sink raft.SnapshotSink) error {
 			return err
 		}
 
+		// Only checksum the data once every node can read the header.
+		if s.store.snapshotChecksum {
+			p = encodeSnapshot(p)
+		}
+
 		// Write data to sink.
 		if _, err := sink.Write(p); err != nil {
 			return err
@@ -2287,12 +3275,48 @@ func (s *storeFSMSnapshot) Persist(sink raft.SnapshotSink) error {
 		return err
 	}
 
+	s.store.notifySnapshot(s.Data.Index)
 	return nil
 }
 
 // Release is invoked when we are finished with the snapshot
 func (s *storeFSMSnapshot) Release() {}
 
+// snapshotMagic starts each raft snapshot and is followed by a big endian
+// CRC-32C of the encoded data. Encoded data never starts with a zero byte
+// so snapshots without a header can be read as legacy snapshots.
+var snapshotMagic = []byte{0x00, 'c', 'r', 'c'}
+
+// snapshotHeaderLen is the length of the magic and the checksum.
+const snapshotHeaderLen = 8
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// encodeSnapshot returns the encoded data b prefixed with a checksum header.
+func encodeSnapshot(b []byte) []byte {
+	buf := make([]byte, snapshotHeaderLen+len(b))
+	copy(buf, snapshotMagic)
+	binary.BigEndian.PutUint32(buf[len(snapshotMagic):], crc32.Checksum(b, crcTable))
+	copy(buf[snapshotHeaderLen:], b)
+	return buf
+}
+
+// decodeSnapshot verifies and strips the checksum header from a snapshot.
+// A snapshot without a header is returned as is with checked set to false.
+func decodeSnapshot(buf []byte) (b []byte, checked bool, err error) {
+	if !bytes.HasPrefix(buf, snapshotMagic) {
+		return buf, false, nil
+	} else if len(buf) < snapshotHeaderLen {
+		return nil, false, ErrSnapshotCorrupt
+	}
+
+	b = buf[snapshotHeaderLen:]
+	if crc32.Checksum(b, crcTable) != binary.BigEndian.Uint32(buf[len(snapshotMagic):]) {
+		return nil, false, ErrSnapshotCorrupt
+	}
+	return b, true, nil
+}
+
 // raftLayer wraps the connection so it can be re-used for forwarding.
 type raftLayer struct {
 	ln     net.Listener
@@ -2338,9 +3362,10 @@ func (l *raftLayer) Close() error { return l.ln.Close() }
 
 // RetentionPolicyUpdate represents retention policy fields to be updated.
 type RetentionPolicyUpdate struct {
-	Name     *string
-	Duration *time.Duration
-	ReplicaN *int
+	Name               *string
+	Duration           *time.Duration
+	ReplicaN           *int
+	ShardGroupDuration *time.Duration
 }
 
 // SetName sets the RetentionPolicyUpdate.Name
@@ -2352,6 +3377,92 @@ func (rpu *RetentionPolicyUpdate) SetDuration(v time.Duration) { rpu.Duration =
 // SetReplicaN sets the RetentionPolicyUpdate.ReplicaN
 func (rpu *RetentionPolicyUpdate) SetReplicaN(v int) { rpu.ReplicaN = &v }
 
+// SetShardGroupDuration sets the RetentionPolicyUpdate.ShardGroupDuration
+func (rpu *RetentionPolicyUpdate) SetShardGroupDuration(v time.Duration) { rpu.ShardGroupDuration = &v }
+
+// retentionPolicyUpdateJSON is the JSON encoding of a RetentionPolicyUpdate.
+// Durations are encoded as strings such as "72h0m0s".
+type retentionPolicyUpdateJSON struct {
+	Name               *string `json:"name,omitempty"`
+	Duration           *string `json:"duration,omitempty"`
+	ReplicaN           *int    `json:"replicaN,omitempty"`
+	ShardGroupDuration *string `json:"shardGroupDuration,omitempty"`
+}
+
+// MarshalJSON encodes the fields that are set. Unset fields are omitted.
+func (rpu *RetentionPolicyUpdate) MarshalJSON() ([]byte, error) {
+	o := retentionPolicyUpdateJSON{Name: rpu.Name, ReplicaN: rpu.ReplicaN}
+	if rpu.Duration != nil {
+		s := rpu.Duration.String()
+		o.Duration = &s
+	}
+	if rpu.ShardGroupDuration != nil {
+		s := rpu.ShardGroupDuration.String()
+		o.ShardGroupDuration = &s
+	}
+	return json.Marshal(&o)
+}
+
+// UnmarshalJSON decodes a partial update. Omitted and null fields are left
+// unset. Durations are accepted in Go ("72h") or InfluxQL ("3d") format.
+func (rpu *RetentionPolicyUpdate) UnmarshalJSON(b []byte) error {
+	var o retentionPolicyUpdateJSON
+	if err := json.Unmarshal(b, &o); err != nil {
+		return err
+	}
+
+	*rpu = RetentionPolicyUpdate{Name: o.Name, ReplicaN: o.ReplicaN}
+	if o.Duration != nil {
+		d, err := parseUpdateDuration(*o.Duration)
+		if err != nil {
+			return fmt.Errorf("invalid duration: %s", err)
+		}
+		rpu.Duration = &d
+	}
+	if o.ShardGroupDuration != nil {
+		d, err := parseUpdateDuration(*o.ShardGroupDuration)
+		if err != nil {
+			return fmt.Errorf("invalid shard group duration: %s", err)
+		}
+		rpu.ShardGroupDuration = &d
+	}
+	return nil
+}
+
+// parseUpdateDuration parses s as a Go duration or an InfluxQL duration literal.
+func parseUpdateDuration(s string) (time.Duration, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return d, nil
+	}
+	if d, err := influxql.ParseDuration(s); err == nil {
+		return d, nil
+	}
+	return 0, fmt.Errorf("%q is not a duration", s)
+}
+
+// Validate returns an error if any field that is set holds an invalid value.
+// A duration must be zero (infinite) or at least MinRetentionPolicyDuration.
+// Checks that depend on the policy being updated are done when it is applied.
+func (rpu *RetentionPolicyUpdate) Validate() error {
+	if rpu.Name != nil && *rpu.Name == "" {
+		return ErrRetentionPolicyNameRequired
+	}
+	if rpu.Duration != nil && *rpu.Duration < MinRetentionPolicyDuration && *rpu.Duration != 0 {
+		return ErrRetentionPolicyDurationTooLow
+	}
+	if rpu.ReplicaN != nil && *rpu.ReplicaN <= 0 {
+		return ErrReplicationFactorTooLow
+	}
+	if rpu.ShardGroupDuration != nil {
+		if *rpu.ShardGroupDuration <= 0 {
+			return ErrRetentionPolicyShardGroupDurationInvalid
+		} else if rpu.Duration != nil && *rpu.Duration != 0 && *rpu.ShardGroupDuration > *rpu.Duration {
+			return ErrRetentionPolicyShardGroupDurationTooHigh
+		}
+	}
+	return nil
+}
+
 // assert will panic with a given formatted message if the given condition is false.
 func assert(condition bool, msg string, v ...interface{}) {
 	if !condition {
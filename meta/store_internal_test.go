@@ -0,0 +1,1071 @@
+package meta
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/hashicorp/raft"
+	"github.com/influxdb/influxdb/meta/internal"
+	"github.com/influxdb/influxdb/toml"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Ensure an atomic write replaces the contents of an existing file.
+func TestWriteFileAtomic(t *testing.T) {
+	dir := mustTempDir()
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "id")
+	if err := ioutil.WriteFile(path, []byte("1"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeFileAtomic(path, []byte("2"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	if b, err := ioutil.ReadFile(path); err != nil {
+		t.Fatal(err)
+	} else if string(b) != "2" {
+		t.Fatalf("unexpected contents: %q", b)
+	}
+
+	// The temporary file should not be left behind.
+	if fis, err := ioutil.ReadDir(dir); err != nil {
+		t.Fatal(err)
+	} else if len(fis) != 1 {
+		t.Fatalf("unexpected files: %d", len(fis))
+	} else if mode := fis[0].Mode().Perm(); mode != 0666 {
+		t.Fatalf("unexpected mode: %04o", mode)
+	}
+}
+
+// Ensure a failed atomic write leaves the previous contents intact and
+// removes its temporary file.
+func TestWriteFileAtomic_Failure(t *testing.T) {
+	dir := mustTempDir()
+	defer os.RemoveAll(dir)
+
+	// A non-empty directory at path can't be replaced by the rename.
+	path := filepath.Join(dir, "id")
+	if err := os.MkdirAll(filepath.Join(path, "partial"), 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeFileAtomic(path, []byte("2"), 0666); err == nil {
+		t.Fatal("expected error")
+	}
+
+	if _, err := os.Stat(filepath.Join(path, "partial")); err != nil {
+		t.Fatalf("previous contents not preserved: %v", err)
+	} else if fis, err := ioutil.ReadDir(dir); err != nil {
+		t.Fatal(err)
+	} else if len(fis) != 1 {
+		t.Fatalf("expected temp file to be removed: %d files", len(fis))
+	}
+}
+
+// Ensure a restarted store reads back its saved id instead of creating a node.
+func TestStore_ReadID(t *testing.T) {
+	dir := mustTempDir()
+	defer os.RemoveAll(dir)
+
+	config := NewConfig()
+	config.Dir = dir
+	s := NewStore(config)
+	if err := ioutil.WriteFile(s.IDPath(), []byte("1"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.readID(); err != nil {
+		t.Fatal(err)
+	} else if s.id != 1 {
+		t.Fatalf("unexpected id: %d", s.id)
+	} else if s.recoverID {
+		t.Fatal("unexpected id recovery")
+	}
+}
+
+// Ensure WaitForLeader returns a timeout error once the clock passes the timeout.
+func TestStore_WaitForLeader_Timeout(t *testing.T) {
+	c := newFakeClock()
+	s := NewStore(NewConfig())
+	s.clock = c
+
+	errCh := make(chan error)
+	go func() { errCh <- s.WaitForLeader(time.Second) }()
+
+	// Wait for the timer and ticker to be created then move past the timeout.
+	c.waitForWaiters(2)
+	c.Add(time.Second)
+
+	if err := <-errCh; err == nil || err.Error() != "timeout" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure WaitForLeader returns on the first tick after a leader is known.
+func TestStore_WaitForLeader_LeaderFound(t *testing.T) {
+	c := newFakeClock()
+	s := NewStore(NewConfig())
+	s.clock = c
+
+	rs := &leaderRaftState{raftState: s.raftState}
+	s.raftState = rs
+
+	errCh := make(chan error)
+	go func() { errCh <- s.WaitForLeader(time.Minute) }()
+	c.waitForWaiters(2)
+
+	// No leader on the first tick.
+	c.Add(100 * time.Millisecond)
+	select {
+	case err := <-errCh:
+		t.Fatalf("unexpected return: %v", err)
+	default:
+	}
+
+	// Elect a leader and tick again.
+	rs.setLeader("127.0.0.1:8088")
+	c.Add(100 * time.Millisecond)
+
+	if err := <-errCh; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure the leader watchdog reports an error once the store has been
+// without a leader for the watchdog duration.
+func TestStore_WatchLeader(t *testing.T) {
+	c := newFakeClock()
+	config := NewConfig()
+	config.LeaderWatchdog = toml.Duration(time.Minute)
+	s := NewStore(config)
+	s.clock = c
+	s.Logger = log.New(ioutil.Discard, "", 0)
+	s.raftState = &leaderRaftState{raftState: s.raftState}
+	defer close(s.closing)
+
+	s.wg.Add(1)
+	go s.watchLeader()
+	c.waitForWaiters(1)
+
+	c.Add(time.Minute)
+	select {
+	case err := <-s.Err():
+		if !strings.Contains(err.Error(), "no raft leader for 1m0s") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for watchdog")
+	}
+}
+
+// Ensure WaitForLeaderContext returns when the context is cancelled.
+func TestStore_WaitForLeaderContext_Cancel(t *testing.T) {
+	c := newFakeClock()
+	s := NewStore(NewConfig())
+	s.clock = c
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error)
+	go func() { errCh <- s.WaitForLeaderContext(ctx, 0) }()
+	c.waitForWaiters(2)
+
+	cancel()
+	if err := <-errCh; err != context.Canceled {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure applying a command on a follower returns the current leader.
+func TestStore_Apply_NotLeader(t *testing.T) {
+	s := NewStore(NewConfig())
+	rs := &leaderRaftState{raftState: s.raftState}
+	rs.setLeader("127.0.0.1:8088")
+	s.raftState = rs
+
+	err := s.apply([]byte("cmd"))
+	if !errors.Is(err, ErrNotLeader) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var e *NotLeaderError
+	if !errors.As(err, &e) {
+		t.Fatalf("unexpected error type: %T", err)
+	} else if e.Leader != "127.0.0.1:8088" {
+		t.Fatalf("unexpected leader: %s", e.Leader)
+	} else if err.Error() != "node is not the leader: leader is 127.0.0.1:8088" {
+		t.Fatalf("unexpected error message: %s", err)
+	}
+}
+
+// Ensure a cached authentication is not used after the TTL expires.
+func TestStore_Authenticate_CacheTTL(t *testing.T) {
+	c := newFakeClock()
+	s := newAuthStore(c, time.Minute)
+
+	if _, err := s.Authenticate("susy", "pass"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Replace the cached hash so a cache hit would fail authentication.
+	au, ok := s.authCache.get("susy")
+	if !ok {
+		t.Fatal("expected cache entry")
+	}
+	au.hash = []byte("stale")
+	s.authCache.set("susy", au)
+
+	// Once expired, the user's bcrypt hash is checked again.
+	c.Add(time.Minute)
+	if _, err := s.Authenticate("susy", "pass"); err != nil {
+		t.Fatal(err)
+	} else if au, _ := s.authCache.get("susy"); string(au.hash) == "stale" {
+		t.Fatal("expected cache entry to be replaced")
+	}
+}
+
+// Ensure the sweeper removes expired entries from the auth cache.
+func TestStore_SweepAuthCache(t *testing.T) {
+	c := newFakeClock()
+	s := newAuthStore(c, time.Minute)
+
+	if _, err := s.Authenticate("susy", "pass"); err != nil {
+		t.Fatal(err)
+	}
+
+	s.wg.Add(1)
+	go s.sweepAuthCache()
+	defer func() {
+		close(s.closing)
+		s.wg.Wait()
+	}()
+	c.waitForWaiters(1)
+	c.Add(time.Minute)
+
+	for i := 0; ; i++ {
+		s.mu.RLock()
+		n := s.authCache.len()
+		s.mu.RUnlock()
+		if n == 0 {
+			break
+		} else if i == 100 {
+			t.Fatalf("expected empty cache, got %d entries", n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Ensure passwords are hashed with the configured cost.
+func TestStore_HashPassword_Cost(t *testing.T) {
+	config := NewConfig()
+	config.HashCost = bcrypt.MinCost + 1
+	s := NewStore(config)
+
+	hash, err := s.hashPassword("pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cost, err := bcrypt.Cost(hash); err != nil {
+		t.Fatal(err)
+	} else if cost != config.HashCost {
+		t.Fatalf("unexpected cost: %d", cost)
+	}
+}
+
+// Ensure the raft snapshot settings are passed to raft.
+func TestLocalRaft_RaftConfig_Snapshot(t *testing.T) {
+	config := NewConfig()
+	config.RaftSnapshotInterval = toml.Duration(time.Minute)
+	config.RaftSnapshotThreshold = 1024
+	s := NewStore(config)
+
+	rc := (&localRaft{store: s}).raftConfig()
+	if rc.SnapshotInterval != time.Minute {
+		t.Fatalf("unexpected snapshot interval: %v", rc.SnapshotInterval)
+	} else if rc.SnapshotThreshold != 1024 {
+		t.Fatalf("unexpected snapshot threshold: %d", rc.SnapshotThreshold)
+	}
+}
+
+// Ensure the raft transport settings are passed to the transport.
+func TestLocalRaft_TransportConfig(t *testing.T) {
+	config := NewConfig()
+	config.RaftTransportTimeout = toml.Duration(30 * time.Second)
+	config.RaftMaxPool = 5
+	s := NewStore(config)
+
+	if maxPool, timeout := (&localRaft{store: s}).transportConfig(); maxPool != 5 {
+		t.Fatalf("unexpected max pool: %d", maxPool)
+	} else if timeout != 30*time.Second {
+		t.Fatalf("unexpected timeout: %v", timeout)
+	}
+
+	// A store built without a config uses the defaults.
+	if maxPool, timeout := (&localRaft{store: &Store{}}).transportConfig(); maxPool != DefaultRaftMaxPool {
+		t.Fatalf("unexpected default max pool: %d", maxPool)
+	} else if timeout != DefaultRaftTransportTimeout {
+		t.Fatalf("unexpected default timeout: %v", timeout)
+	}
+}
+
+// Benchmarks of reading part of the data compared with cloning all of it.
+func BenchmarkStore_DatabaseNames(b *testing.B) {
+	s := newBenchmarkStore(b, 100)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if names := s.DatabaseNames(); len(names) != 100 {
+			b.Fatalf("unexpected names: %d", len(names))
+		}
+	}
+}
+
+func BenchmarkStore_DatabaseNames_Clone(b *testing.B) {
+	s := newBenchmarkStore(b, 100)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		data := s.cachedData().Clone()
+		names := make([]string, len(data.Databases))
+		for j := range data.Databases {
+			names[j] = data.Databases[j].Name
+		}
+	}
+}
+
+func BenchmarkStore_RetentionPolicy(b *testing.B) {
+	s := newBenchmarkStore(b, 100)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if rpi, err := s.RetentionPolicy("db50", "rp0"); err != nil || rpi == nil {
+			b.Fatalf("unexpected policy: %v, %v", rpi, err)
+		}
+	}
+}
+
+func BenchmarkStore_RetentionPolicy_Clone(b *testing.B) {
+	s := newBenchmarkStore(b, 100)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if rpi, err := s.cachedData().Clone().RetentionPolicy("db50", "rp0"); err != nil || rpi == nil {
+			b.Fatalf("unexpected policy: %v, %v", rpi, err)
+		}
+	}
+}
+
+func BenchmarkStore_Node(b *testing.B) {
+	s := newBenchmarkStore(b, 100)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if ni, err := s.Node(1); err != nil || ni == nil {
+			b.Fatalf("unexpected node: %v, %v", ni, err)
+		}
+	}
+}
+
+func BenchmarkStore_Node_Clone(b *testing.B) {
+	s := newBenchmarkStore(b, 100)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if ni := s.cachedData().Clone().Node(1); ni == nil {
+			b.Fatal("node not found")
+		}
+	}
+}
+
+// Benchmarks of copying all of the data for a given number of databases.
+func BenchmarkData_Clone_10(b *testing.B)   { benchmarkDataClone(b, 10) }
+func BenchmarkData_Clone_100(b *testing.B)  { benchmarkDataClone(b, 100) }
+func BenchmarkData_Clone_1000(b *testing.B) { benchmarkDataClone(b, 1000) }
+
+func benchmarkDataClone(b *testing.B, n int) {
+	data := newBenchmarkStore(b, n).data
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if other := data.Clone(); len(other.Databases) != n {
+			b.Fatalf("unexpected databases: %d", len(other.Databases))
+		}
+	}
+}
+
+// Benchmarks of applying a command with and without a goroutine waiting
+// for the data to change.
+func BenchmarkStoreFSM_Apply_NoWatchers(b *testing.B) { benchmarkStoreFSMApply(b, false) }
+func BenchmarkStoreFSM_Apply_Watcher(b *testing.B)    { benchmarkStoreFSMApply(b, true) }
+
+func benchmarkStoreFSMApply(b *testing.B, watch bool) {
+	s := NewStore(NewConfig())
+	fsm := (*storeFSM)(s)
+
+	// The user doesn't exist, so the data stays empty and only its index
+	// changes with each command.
+	cmd := mustMarshalCommand(internal.Command_SetAdminPrivilegeCommand, internal.E_SetAdminPrivilegeCommand_Command,
+		&internal.SetAdminPrivilegeCommand{Username: proto.String("susy"), Admin: proto.Bool(true)})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if watch {
+			s.dataIndex()
+		}
+		fsm.Apply(&raft.Log{Index: uint64(i + 1), Term: 1, Data: cmd})
+	}
+}
+
+// Benchmark of reading the shared data, as done for each fetch.
+func BenchmarkStore_CachedData(b *testing.B) {
+	s := newBenchmarkStore(b, 100)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if data := s.cachedData(); len(data.Databases) != 100 {
+			b.Fatalf("unexpected databases: %d", len(data.Databases))
+		}
+	}
+}
+
+// newBenchmarkStore returns an unopened store holding n databases with a
+// retention policy and shard group each.
+func newBenchmarkStore(b *testing.B, n int) *Store {
+	data := &Data{}
+	if err := data.CreateNode("127.0.0.1:8088"); err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		db := fmt.Sprintf("db%d", i)
+		if err := data.CreateDatabase(db); err != nil {
+			b.Fatal(err)
+		} else if err := data.CreateRetentionPolicy(db, &RetentionPolicyInfo{Name: "rp0", ReplicaN: 1, Duration: time.Hour}); err != nil {
+			b.Fatal(err)
+		} else if err := data.CreateShardGroup(db, "rp0", time.Unix(0, 0)); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	s := NewStore(NewConfig())
+	s.data = data
+	return s
+}
+
+func BenchmarkStore_HashPassword_Cost4(b *testing.B)  { benchmarkStoreHashPassword(b, 4) }
+func BenchmarkStore_HashPassword_Cost10(b *testing.B) { benchmarkStoreHashPassword(b, 10) }
+func BenchmarkStore_HashPassword_Cost12(b *testing.B) { benchmarkStoreHashPassword(b, 12) }
+
+func benchmarkStoreHashPassword(b *testing.B, cost int) {
+	config := NewConfig()
+	config.HashCost = cost
+	s := NewStore(config)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := s.hashPassword("pass"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Ensure auth cache hits, misses and evictions are counted.
+func TestStore_Authenticate_CacheStatistics(t *testing.T) {
+	c := newFakeClock()
+	s := newAuthStore(c, time.Minute)
+
+	// The first authentication misses and the second hits.
+	for i := 0; i < 2; i++ {
+		if _, err := s.Authenticate("susy", "pass"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// An expired entry is evicted and counted as a miss.
+	c.Add(time.Minute)
+	if _, err := s.Authenticate("susy", "pass"); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := s.Statistics()
+	if stats["auth_cache_hits"] != "1" {
+		t.Fatalf("unexpected hits: %s", stats["auth_cache_hits"])
+	} else if stats["auth_cache_misses"] != "2" {
+		t.Fatalf("unexpected misses: %s", stats["auth_cache_misses"])
+	} else if stats["auth_cache_evictions"] != "1" {
+		t.Fatalf("unexpected evictions: %s", stats["auth_cache_evictions"])
+	}
+}
+
+// newAuthStore returns an unopened store with a single user "susy" with
+// the password "pass".
+func newAuthStore(c clock, ttl time.Duration) *Store {
+	config := NewConfig()
+	config.AuthCacheTTL = toml.Duration(ttl)
+	s := NewStore(config)
+	s.clock = c
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("pass"), bcrypt.MinCost)
+	if err != nil {
+		panic(err)
+	}
+	if err := s.data.CreateUser("susy", string(hash), true); err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// Ensure joining a cluster retries until a peer accepts the join.
+func TestStore_JoinCluster_Retry(t *testing.T) {
+	dir := mustTempDir()
+	defer os.RemoveAll(dir)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	localAddr := "127.0.0.1:8088"
+	serverRPC := &rpc{
+		store: &fakeStore{leader: localAddr, newNodeID: 100},
+	}
+
+	// Drop the first two connections to simulate an unavailable peer.
+	var mu sync.Mutex
+	var attempts int
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			mu.Lock()
+			attempts++
+			n := attempts
+			mu.Unlock()
+			if n < 3 {
+				conn.Close()
+				continue
+			}
+
+			// Demux...
+			if _, err := conn.Read(make([]byte, 1)); err != nil {
+				conn.Close()
+				continue
+			}
+			serverRPC.handleRPCConn(conn)
+		}
+	}()
+
+	config := NewConfig()
+	config.Dir = dir
+	config.Peers = []string{ln.Addr().String()}
+	config.JoinRetryInterval = toml.Duration(time.Millisecond)
+	config.JoinRetryMaxInterval = toml.Duration(2 * time.Millisecond)
+	s := NewStore(config)
+	s.RemoteAddr = &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8088}
+
+	if err := s.joinCluster(context.Background()); err != nil {
+		t.Fatal(err)
+	} else if s.id != 100 {
+		t.Fatalf("unexpected node id: %d", s.id)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if attempts != 3 {
+		t.Fatalf("unexpected attempts: %d", attempts)
+	}
+
+	if v := s.Statistics()["join_retries"]; v != "2" {
+		t.Fatalf("unexpected join retries: %s", v)
+	}
+}
+
+// Ensure joining a cluster gives up once the retry timeout passes.
+func TestStore_JoinCluster_Timeout(t *testing.T) {
+	// Reserve an address with nothing listening on it.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	config := NewConfig()
+	config.Peers = []string{addr}
+	config.JoinRetryInterval = toml.Duration(time.Millisecond)
+	config.JoinRetryTimeout = toml.Duration(20 * time.Millisecond)
+	s := NewStore(config)
+	s.RemoteAddr = &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8088}
+
+	if err := s.joinCluster(context.Background()); err == nil {
+		t.Fatal("expected error")
+	} else if !strings.Contains(err.Error(), addr) {
+		t.Fatalf("expected error to list failed peer: %v", err)
+	}
+}
+
+// Ensure the join timeout, measured by the store's clock, bounds a join
+// request that a peer never answers.
+func TestStore_JoinCluster_JoinTimeout(t *testing.T) {
+	// Accept connections but never respond.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	config := NewConfig()
+	config.Peers = []string{ln.Addr().String()}
+	config.RPCTimeout = 0
+	config.JoinTimeout = toml.Duration(time.Minute)
+	c := newFakeClock()
+	s := NewStore(config)
+	s.clock = c
+	s.RemoteAddr = &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8088}
+
+	errCh := make(chan error)
+	go func() { errCh <- s.joinCluster(context.Background()) }()
+
+	// Move past the join timeout once its timer is created.
+	c.waitForWaiters(1)
+	c.Add(time.Minute)
+
+	if err := <-errCh; err == nil {
+		t.Fatal("expected error")
+	} else if !strings.Contains(err.Error(), "join request to "+ln.Addr().String()+" did not complete") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure joining a cluster falls back to the next peer when one is down.
+func TestStore_JoinCluster_Failover(t *testing.T) {
+	dir := mustTempDir()
+	defer os.RemoveAll(dir)
+
+	// Reserve an address with nothing listening on it.
+	dead, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead.Close()
+
+	srv := newTestServer(t, &rpc{
+		store: &fakeStore{leader: "127.0.0.1:8088", newNodeID: 100},
+	})
+	defer srv.Close()
+	go srv.Serve()
+	<-srv.Ready
+
+	config := NewConfig()
+	config.Dir = dir
+	config.Peers = []string{dead.Addr().String(), srv.Listener.Addr().String()}
+	s := NewStore(config)
+	s.RemoteAddr = &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8088}
+
+	if err := s.joinCluster(context.Background()); err != nil {
+		t.Fatal(err)
+	} else if s.id != 100 {
+		t.Fatalf("unexpected node id: %d", s.id)
+	}
+}
+
+// Ensure join validation reports each unreachable peer without joining.
+func TestStore_ValidateJoin(t *testing.T) {
+	// Reserve an address with nothing listening on it.
+	dead, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead.Close()
+
+	data := &Data{Index: 10}
+	if err := data.CreateNode("127.0.0.1:8088"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each test server accepts a single connection so each check gets its own.
+	srv0 := newTestServer(t, &rpc{store: &fakeStore{md: data}})
+	defer srv0.Close()
+	go srv0.Serve()
+	<-srv0.Ready
+
+	srv1 := newTestServer(t, &rpc{store: &fakeStore{md: data}})
+	defer srv1.Close()
+	go srv1.Serve()
+	<-srv1.Ready
+
+	s := NewStore(NewConfig())
+	if err := s.ValidateJoin(context.Background(), []string{srv0.Listener.Addr().String()}); err != nil {
+		t.Fatal(err)
+	}
+
+	live := srv1.Listener.Addr().String()
+	peers := []string{live, dead.Addr().String()}
+	err = s.ValidateJoin(context.Background(), peers)
+	verr, ok := err.(*JoinValidationError)
+	if !ok {
+		t.Fatalf("unexpected error: %v", err)
+	} else if _, ok := verr.Errs[live]; ok {
+		t.Fatalf("unexpected error for reachable peer: %v", verr.Errs[live])
+	} else if verr.Errs[dead.Addr().String()] == nil {
+		t.Fatalf("expected error for unreachable peer: %v", err)
+	} else if !strings.Contains(err.Error(), dead.Addr().String()) {
+		t.Fatalf("expected error to list failed peer: %v", err)
+	}
+
+	// Validation doesn't join.
+	if s.id != 0 {
+		t.Fatalf("unexpected node id: %d", s.id)
+	}
+}
+
+// Ensure snapshots and cached data are isolated from later commands,
+// including commands that fail and leave the data unchanged.
+func TestStoreFSM_Snapshot_Isolated(t *testing.T) {
+	s := NewStore(NewConfig())
+	fsm := (*storeFSM)(s)
+	apply := func(index uint64, name string) {
+		b := mustMarshalCommand(internal.Command_CreateDatabaseCommand, internal.E_CreateDatabaseCommand_Command,
+			&internal.CreateDatabaseCommand{Name: proto.String(name)})
+		fsm.Apply(&raft.Log{Index: index, Term: 1, Data: b})
+	}
+	apply(1, "db0")
+
+	snapshot, err := fsm.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached := s.cachedData()
+
+	// Fail to create the database again then create another.
+	apply(2, "db0")
+	apply(3, "db1")
+
+	for _, data := range []*Data{snapshot.(*storeFSMSnapshot).Data, cached} {
+		if data.Index != 1 || len(data.Databases) != 1 {
+			t.Fatalf("unexpected data: index=%d, databases=%d", data.Index, len(data.Databases))
+		}
+	}
+	if s.data.Index != 3 || len(s.data.Databases) != 2 {
+		t.Fatalf("unexpected store data: index=%d, databases=%d", s.data.Index, len(s.data.Databases))
+	}
+}
+
+// Ensure a goroutine that starts waiting after earlier changes is woken by
+// the next change.
+func TestStore_DataIndex_Changed(t *testing.T) {
+	s := NewStore(NewConfig())
+	fsm := (*storeFSM)(s)
+	apply := func(index uint64, name string) {
+		b := mustMarshalCommand(internal.Command_CreateDatabaseCommand, internal.E_CreateDatabaseCommand_Command,
+			&internal.CreateDatabaseCommand{Name: proto.String(name)})
+		fsm.Apply(&raft.Log{Index: index, Term: 1, Data: b})
+	}
+	apply(1, "db0")
+	apply(2, "db1")
+
+	index, changed := s.dataIndex()
+	if index != 2 {
+		t.Fatalf("unexpected index: %d", index)
+	}
+	select {
+	case <-changed:
+		t.Fatal("unexpected change")
+	default:
+	}
+
+	apply(3, "db2")
+	select {
+	case <-changed:
+	default:
+		t.Fatal("expected change")
+	}
+	if index, _ := s.dataIndex(); index != 3 {
+		t.Fatalf("unexpected index: %d", index)
+	}
+}
+
+// mustMarshalCommand returns the encoding of a command of type typ with
+// value set as its extension.
+func mustMarshalCommand(typ internal.Command_Type, desc *proto.ExtensionDesc, value interface{}) []byte {
+	cmd := &internal.Command{Type: &typ}
+	if err := proto.SetExtension(cmd, desc, value); err != nil {
+		panic(err)
+	}
+	b, err := proto.Marshal(cmd)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// Ensure a snapshot with a changed byte fails to restore and that snapshots
+// without a checksum are still restored.
+func TestStoreFSM_Restore_Checksum(t *testing.T) {
+	data := &Data{Index: 10}
+	if err := data.CreateDatabase("db0"); err != nil {
+		t.Fatal(err)
+	}
+	b, err := data.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	s := NewStore(NewConfig())
+	s.Logger = log.New(&buf, "", 0)
+	fsm := (*storeFSM)(s)
+
+	// A checksummed snapshot is restored.
+	if err := fsm.Restore(ioutil.NopCloser(bytes.NewReader(encodeSnapshot(b)))); err != nil {
+		t.Fatal(err)
+	} else if s.data.Index != 10 || s.data.Database("db0") == nil {
+		t.Fatalf("unexpected data: %#v", s.data)
+	}
+
+	// Flip a byte in the header and in the data.
+	for _, i := range []int{len(snapshotMagic), snapshotHeaderLen + len(b)/2} {
+		corrupt := encodeSnapshot(b)
+		corrupt[i] ^= 0xff
+		if err := fsm.Restore(ioutil.NopCloser(bytes.NewReader(corrupt))); err != ErrSnapshotCorrupt {
+			t.Fatalf("byte %d: unexpected error: %v", i, err)
+		}
+	}
+
+	// A truncated header is corrupt.
+	if err := fsm.Restore(ioutil.NopCloser(bytes.NewReader(snapshotMagic))); err != ErrSnapshotCorrupt {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// A snapshot without a checksum is restored silently when checksums
+	// are disabled.
+	s.data = &Data{}
+	if err := fsm.Restore(ioutil.NopCloser(bytes.NewReader(b))); err != nil {
+		t.Fatal(err)
+	} else if s.data.Index != 10 {
+		t.Fatalf("unexpected index: %d", s.data.Index)
+	} else if buf.Len() != 0 {
+		t.Fatalf("unexpected warning: %q", buf.String())
+	}
+
+	// It is restored with a warning when checksums are enabled.
+	s.snapshotChecksum = true
+	s.data = &Data{}
+	if err := fsm.Restore(ioutil.NopCloser(bytes.NewReader(b))); err != nil {
+		t.Fatal(err)
+	} else if s.data.Index != 10 {
+		t.Fatalf("unexpected index: %d", s.data.Index)
+	} else if !strings.Contains(buf.String(), "without checksum") {
+		t.Fatalf("expected warning: %q", buf.String())
+	}
+}
+
+// Ensure snapshots are only persisted with a checksum when it is enabled.
+func TestStoreFSMSnapshot_Persist_Checksum(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		c := NewConfig()
+		c.SnapshotChecksum = enabled
+		s := NewStore(c)
+
+		snapshot, err := (*storeFSM)(s).Snapshot()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var sink bufferSink
+		if err := snapshot.Persist(&sink); err != nil {
+			t.Fatal(err)
+		} else if checked := bytes.HasPrefix(sink.Bytes(), snapshotMagic); checked != enabled {
+			t.Fatalf("enabled=%v: unexpected checksum header: %v", enabled, checked)
+		}
+	}
+}
+
+// bufferSink is a raft.SnapshotSink that writes to memory.
+type bufferSink struct {
+	bytes.Buffer
+}
+
+func (s *bufferSink) ID() string    { return "buffer" }
+func (s *bufferSink) Cancel() error { return nil }
+func (s *bufferSink) Close() error  { return nil }
+
+// Ensure the join backoff doubles up to the maximum with jitter.
+func TestJoinBackoff(t *testing.T) {
+	for _, tt := range []struct {
+		attempt  int
+		min, max time.Duration
+		exp      time.Duration
+	}{
+		{attempt: 0, min: time.Second, max: time.Minute, exp: time.Second},
+		{attempt: 1, min: time.Second, max: time.Minute, exp: 2 * time.Second},
+		{attempt: 3, min: time.Second, max: time.Minute, exp: 8 * time.Second},
+		{attempt: 10, min: time.Second, max: time.Minute, exp: time.Minute},
+		{attempt: 5, min: time.Second, max: 0, exp: time.Second},
+		{attempt: 0, min: 0, max: 0, exp: DefaultJoinRetryInterval},
+	} {
+		for i := 0; i < 10; i++ {
+			if d := joinBackoff(tt.attempt, tt.min, tt.max); d < tt.exp/2 || d > tt.exp {
+				t.Fatalf("attempt %d: backoff %v not in [%v, %v]", tt.attempt, d, tt.exp/2, tt.exp)
+			}
+		}
+	}
+}
+
+// leaderRaftState overrides the leader reported by a raftState.
+type leaderRaftState struct {
+	raftState
+	mu   sync.Mutex
+	addr string
+}
+
+func (r *leaderRaftState) setLeader(addr string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.addr = addr
+}
+
+func (r *leaderRaftState) leader() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.addr
+}
+
+// fakeClock is a clock that only advances when Add is called.
+// Events are delivered synchronously by Add.
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*fakeWaiter
+	added   chan struct{}
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{
+		now:   time.Unix(0, 0),
+		added: make(chan struct{}, 100),
+	}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) timer { return c.newWaiter(d, false) }
+
+func (c *fakeClock) NewTicker(d time.Duration) ticker { return fakeTicker{c.newWaiter(d, true)} }
+
+func (c *fakeClock) newWaiter(d time.Duration, repeat bool) *fakeWaiter {
+	c.mu.Lock()
+	w := &fakeWaiter{
+		c:      make(chan time.Time, 1),
+		next:   c.now.Add(d),
+		period: d,
+		repeat: repeat,
+		mu:     &c.mu,
+	}
+	c.waiters = append(c.waiters, w)
+	c.mu.Unlock()
+
+	// Signal outside the lock so a full channel can't block Add or Now.
+	c.added <- struct{}{}
+	return w
+}
+
+// waitForWaiters blocks until n timers or tickers have been created.
+func (c *fakeClock) waitForWaiters(n int) {
+	for i := 0; i < n; i++ {
+		select {
+		case <-c.added:
+		case <-time.After(5 * time.Second):
+			panic("timed out waiting for timers")
+		}
+	}
+}
+
+// Add moves the clock forward by d and fires any expired timers and tickers.
+func (c *fakeClock) Add(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+	for _, w := range c.waiters {
+		if w.stopped || w.next.After(c.now) {
+			continue
+		}
+
+		select {
+		case w.c <- c.now:
+		default:
+		}
+
+		if w.repeat && w.period > 0 {
+			for !w.next.After(c.now) {
+				w.next = w.next.Add(w.period)
+			}
+		} else {
+			w.stopped = true
+		}
+	}
+}
+
+type fakeWaiter struct {
+	c       chan time.Time
+	next    time.Time
+	period  time.Duration
+	repeat  bool
+	stopped bool
+	mu      *sync.Mutex
+}
+
+func (w *fakeWaiter) C() <-chan time.Time { return w.c }
+
+func (w *fakeWaiter) Stop() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	stopped := w.stopped
+	w.stopped = true
+	return !stopped
+}
+
+// fakeTicker adapts a fakeWaiter to the ticker interface.
+type fakeTicker struct{ *fakeWaiter }
+
+func (t fakeTicker) Stop() { t.fakeWaiter.Stop() }
+
+// mustTempDir returns the path to a new temporary directory.
+func mustTempDir() string {
+	dir, err := ioutil.TempDir("", "influxdb-meta-")
+	if err != nil {
+		panic(err)
+	}
+	return dir
+}
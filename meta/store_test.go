@@ -2,6 +2,10 @@ package meta_test
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -11,12 +15,16 @@ import (
 	"reflect"
 	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/gogo/protobuf/proto"
 	"github.com/influxdb/influxdb"
+	"github.com/influxdb/influxdb/influxql"
 	"github.com/influxdb/influxdb/meta"
+	"github.com/influxdb/influxdb/meta/internal"
 	"github.com/influxdb/influxdb/tcp"
 	"github.com/influxdb/influxdb/toml"
 	"golang.org/x/crypto/bcrypt"
@@ -33,6 +41,24 @@ func TestStore_Open_ErrStoreOpen(t *testing.T) {
 	}
 }
 
+// Ensure that opening a second store on the same directory returns an error.
+func TestStore_Open_ErrDirLocked(t *testing.T) {
+	t.Parallel()
+	s := MustOpenStore()
+	defer s.Close()
+
+	other := NewStore(NewConfig(s.Path()))
+	err := other.Open()
+	other.Close()
+
+	var lockErr *meta.DirLockedError
+	if !errors.Is(err, meta.ErrDirLocked) {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !errors.As(err, &lockErr) || lockErr.PID != os.Getpid() {
+		t.Fatalf("unexpected lock holder: %v", err)
+	}
+}
+
 // Ensure that opening a store with more than 3 peers returns an error.
 func TestStore_Open_ErrTooManyPeers(t *testing.T) {
 	t.Parallel()
@@ -44,6 +70,568 @@ func TestStore_Open_ErrTooManyPeers(t *testing.T) {
 	}
 }
 
+// Ensure a data dir that can't be created returns ErrDataDirUnwritable.
+func TestStore_Open_ErrDataDirUnwritable(t *testing.T) {
+	t.Parallel()
+	path := MustTempFile()
+	defer os.RemoveAll(path)
+	if err := ioutil.WriteFile(path, nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewStore(NewConfig(filepath.Join(path, "meta")))
+	defer s.Close()
+	err := s.Open()
+	var openErr *meta.OpenError
+	if !errors.Is(err, meta.ErrDataDirUnwritable) {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !errors.As(err, &openErr) || openErr.Err == nil {
+		t.Fatalf("expected underlying cause: %v", err)
+	}
+}
+
+// Ensure a read-only data dir returns ErrDataDirUnwritable.
+func TestStore_Open_ErrDataDirReadOnly(t *testing.T) {
+	t.Parallel()
+	if os.Getuid() == 0 {
+		t.Skip("root can write to read-only directories")
+	}
+	path := MustTempFile()
+	defer os.RemoveAll(path)
+	if err := os.Mkdir(path, 0555); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewStore(NewConfig(path))
+	defer s.Close()
+	if err := s.Open(); !errors.Is(err, meta.ErrDataDirUnwritable) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure the data directory and id file are created with the configured mode.
+func TestStore_Open_DataDirMode(t *testing.T) {
+	t.Parallel()
+	c := NewConfig(MustTempFile())
+	c.DataDirMode = 0750
+	s := NewStore(c)
+	if err := s.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	<-s.Ready()
+
+	// The umask may clear group and other bits, but never adds them.
+	if fi, err := os.Stat(s.Path()); err != nil {
+		t.Fatal(err)
+	} else if mode := fi.Mode().Perm(); mode&^0750 != 0 || mode&0700 != 0700 {
+		t.Fatalf("unexpected dir mode: %04o", mode)
+	}
+	if fi, err := os.Stat(s.IDPath()); err != nil {
+		t.Fatal(err)
+	} else if mode := fi.Mode().Perm(); mode&^0640 != 0 {
+		t.Fatalf("unexpected id file mode: %04o", mode)
+	}
+}
+
+// Ensure raft storage that can't be opened returns ErrRaftOpen.
+func TestStore_Open_ErrRaftOpen(t *testing.T) {
+	t.Parallel()
+	path := MustTempFile()
+	defer os.RemoveAll(path)
+
+	// A regular file in place of the snapshot directory.
+	if err := os.MkdirAll(path, 0777); err != nil {
+		t.Fatal(err)
+	} else if err := ioutil.WriteFile(filepath.Join(path, "snapshots"), nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewStore(NewConfig(path))
+	defer s.Close()
+	if err := s.Open(); !errors.Is(err, meta.ErrRaftOpen) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure a cluster that can't be joined returns ErrJoinFailed.
+func TestStore_Open_ErrJoinFailed(t *testing.T) {
+	t.Parallel()
+
+	// Reserve an address with nothing listening on it.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	config := NewConfig(MustTempFile())
+	config.Peers = []string{addr}
+	config.JoinRetryInterval = toml.Duration(time.Millisecond)
+	config.JoinRetryTimeout = toml.Duration(20 * time.Millisecond)
+	s := NewStore(config)
+	defer os.RemoveAll(s.Path())
+	defer s.Close()
+	if err := s.Open(); !errors.Is(err, meta.ErrJoinFailed) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure the store only logs messages at or above the configured level.
+func TestStore_Open_LogLevel(t *testing.T) {
+	t.Parallel()
+
+	for i, tt := range []struct {
+		level  string
+		logged bool
+	}{
+		{level: "", logged: true},
+		{level: "info", logged: true},
+		{level: "warn", logged: false},
+		{level: "error", logged: false},
+	} {
+		c := NewConfig(MustTempFile())
+		c.LogLevel = tt.level
+		s := NewStore(c)
+		s.Logger = log.New(&s.Stderr, "", 0)
+		if err := s.Open(); err != nil {
+			t.Fatal(err)
+		}
+		s.Close()
+
+		if logged := bytes.Contains(s.Stderr.Bytes(), []byte("Using data dir")); logged != tt.logged {
+			t.Errorf("%d. %q: unexpected info logged: %v", i, tt.level, logged)
+		}
+	}
+}
+
+// Ensure a reloaded log level takes effect on an open store.
+func TestStore_Reload_LogLevel(t *testing.T) {
+	t.Parallel()
+
+	c := NewConfig(MustTempFile())
+	c.LogLevel = "error"
+	s := NewStore(c)
+	s.Logger = log.New(&s.Stderr, "", 0)
+	if err := s.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if bytes.Contains(s.Stderr.Bytes(), []byte("Using data dir")) {
+		t.Fatal("unexpected info logged before reload")
+	}
+
+	other := *c
+	other.LogLevel = "info"
+	if err := s.Reload(&other); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Contains(s.Stderr.Bytes(), []byte("Reloaded config: log-level=info")) {
+		t.Fatalf("expected info logged after reload: %s", s.Stderr.String())
+	}
+}
+
+// Ensure a reload that changes a setting needing a restart is rejected.
+func TestStore_Reload_ErrNotReloadable(t *testing.T) {
+	t.Parallel()
+
+	c := NewConfig(MustTempFile())
+	s := NewStore(c)
+
+	for i, tt := range []struct {
+		fn  func(c *meta.Config)
+		err string
+	}{
+		{fn: func(c *meta.Config) { c.BindAddress = "127.0.0.1:8089" }, err: "Meta.BindAddress cannot be changed without a restart"},
+		{fn: func(c *meta.Config) { c.Dir = "/tmp/other" }, err: "Meta.Dir cannot be changed without a restart"},
+		{fn: func(c *meta.Config) { c.LogLevel = "verbose" }, err: "Meta.LogLevel must be one of debug, info, warn or error, got verbose"},
+	} {
+		other := *c
+		tt.fn(&other)
+		if err := s.Reload(&other); err == nil || err.Error() != tt.err {
+			t.Errorf("%d. unexpected error: %v", i, err)
+		}
+	}
+
+	// Peers are set from the command line, not the config file.
+	other := *c
+	other.Peers = []string{"localhost:8088"}
+	if err := s.Reload(&other); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that opening a store with invalid node state on disk returns an error.
+func TestStore_Open_ErrNodeInvalid(t *testing.T) {
+	t.Parallel()
+
+	for i, tt := range []struct {
+		file     string
+		contents string
+	}{
+		{file: "id", contents: "0"},
+		{file: "id", contents: "abc"},
+		{file: "peers.json", contents: `["localhost"]`},
+		{file: "peers.json", contents: `[":8088"]`},
+		{file: "peers.json", contents: `["localhost:http"]`},
+	} {
+		path := MustTempFile()
+		if err := os.MkdirAll(path, 0777); err != nil {
+			t.Fatal(err)
+		} else if err := ioutil.WriteFile(filepath.Join(path, tt.file), []byte(tt.contents), 0666); err != nil {
+			t.Fatal(err)
+		}
+
+		s := NewStore(NewConfig(path))
+		err := s.Open()
+		s.Close()
+
+		if !errors.Is(err, meta.ErrNodeInvalid) {
+			t.Errorf("%d. %s=%s: unexpected error: %v", i, tt.file, tt.contents, err)
+		}
+	}
+}
+
+// Ensure that opening a store with an empty id file creates a new node.
+func TestStore_Open_EmptyID(t *testing.T) {
+	t.Parallel()
+	path := MustTempFile()
+	if err := os.MkdirAll(path, 0777); err != nil {
+		t.Fatal(err)
+	} else if err := ioutil.WriteFile(filepath.Join(path, "id"), nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewStore(NewConfig(path))
+	s.Logger = log.New(&s.Stderr, "", 0)
+	if err := s.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	select {
+	case err := <-s.Err():
+		t.Fatal(err)
+	case <-s.Ready():
+	}
+
+	if id := s.NodeID(); id != 1 {
+		t.Fatalf("unexpected node id: %d", id)
+	} else if b, err := ioutil.ReadFile(s.IDPath()); err != nil {
+		t.Fatal(err)
+	} else if string(b) != "1" {
+		t.Fatalf("unexpected id file: %q", b)
+	} else if !strings.Contains(s.Stderr.String(), "is empty") {
+		t.Fatalf("expected warning: %q", s.Stderr.String())
+	}
+}
+
+// Ensure the first node gets id 1, later nodes get increasing ids and a
+// restarted store keeps its saved id.
+func TestStore_Open_RestartID(t *testing.T) {
+	t.Parallel()
+	s := MustOpenStore()
+	defer func() { s.Close() }()
+
+	if id := s.NodeID(); id != 1 {
+		t.Fatalf("unexpected node id: %d", id)
+	}
+	for i, host := range []string{"host2:8088", "host3:8088"} {
+		if ni, err := s.CreateNode(host); err != nil {
+			t.Fatal(err)
+		} else if ni.ID != uint64(i+2) {
+			t.Fatalf("%d. unexpected node id: %d", i, ni.ID)
+		}
+	}
+
+	// Restart on the same address so the raft peer is unchanged.
+	addr, path := s.Addr.String(), s.Path()
+	s.LeaveFiles = true
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	s = MustOpenStoreWithPath(addr, path)
+	s.LeaveFiles = false
+
+	if id := s.NodeID(); id != 1 {
+		t.Fatalf("unexpected node id after restart: %d", id)
+	} else if b, err := ioutil.ReadFile(s.IDPath()); err != nil {
+		t.Fatal(err)
+	} else if string(b) != "1" {
+		t.Fatalf("unexpected id file after restart: %q", b)
+	}
+}
+
+// Ensure the store reports whether it is open.
+func TestStore_IsOpen(t *testing.T) {
+	t.Parallel()
+	s := NewStore(NewConfig(MustTempFile()))
+	if s.IsOpen() {
+		t.Fatal("expected store to be closed before open")
+	}
+
+	if err := s.Open(); err != nil {
+		t.Fatal(err)
+	} else if !s.IsOpen() {
+		t.Fatal("expected store to be open")
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	} else if s.IsOpen() {
+		t.Fatal("expected store to be closed after close")
+	}
+}
+
+// Ensure closing a store more than once is a no-op.
+func TestStore_Close_Twice(t *testing.T) {
+	t.Parallel()
+	s := MustOpenStore()
+	for i := 0; i < 2; i++ {
+		if err := s.Close(); err != nil {
+			t.Fatalf("%d. unexpected error: %s", i, err)
+		}
+	}
+}
+
+// Ensure a store can run on the in-memory raft store without writing a log.
+func TestStore_Open_RaftStoreInmem(t *testing.T) {
+	t.Parallel()
+	config := NewConfig(MustTempFile())
+	config.RaftStoreType = meta.RaftStoreInmem
+	s := NewStore(config)
+	if err := s.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	select {
+	case err := <-s.Err():
+		t.Fatal(err)
+	case <-s.Ready():
+	}
+
+	if _, err := s.CreateDatabase("db0"); err != nil {
+		t.Fatal(err)
+	} else if err := s.Snapshot(); err != nil {
+		t.Fatal(err)
+	} else if di, err := s.Database("db0"); err != nil {
+		t.Fatal(err)
+	} else if di == nil {
+		t.Fatal("expected database")
+	}
+
+	if _, err := os.Stat(filepath.Join(s.Path(), "raft.db")); !os.IsNotExist(err) {
+		t.Fatalf("unexpected raft.db: %v", err)
+	}
+}
+
+// Ensure the store reports leadership changes and closes the channel on close.
+func TestStore_LeaderCh(t *testing.T) {
+	t.Parallel()
+	s := MustOpenStore()
+
+	select {
+	case leader := <-s.LeaderCh():
+		if leader != s.Addr.String() {
+			t.Fatalf("unexpected leader: %s", leader)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for leader change")
+	}
+
+	s.Close()
+	for range s.LeaderCh() {
+	}
+}
+
+// Ensure a consistent read from a closed store returns an error.
+func TestStore_ReadConsistent_Closed(t *testing.T) {
+	t.Parallel()
+	s := MustOpenStore()
+	s.Close()
+
+	if _, err := s.ReadConsistent(); err != meta.ErrStoreClosed {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure the store delivers the new index once the data advances.
+func TestStore_AfterIndexWithValue(t *testing.T) {
+	t.Parallel()
+	s := MustOpenStore()
+	defer s.Close()
+
+	data, err := s.ReadConsistent()
+	if err != nil {
+		t.Fatal(err)
+	}
+	index := data.Index
+
+	// An index that has already been passed is delivered immediately.
+	select {
+	case v := <-s.AfterIndexWithValue(index - 1):
+		if v != index {
+			t.Fatalf("unexpected index: %d", v)
+		}
+	default:
+		t.Fatal("expected index to be available")
+	}
+
+	ch := s.AfterIndexWithValue(index)
+	if _, err := s.CreateDatabase("db0"); err != nil {
+		t.Fatal(err)
+	}
+	if data, err = s.ReadConsistent(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case v := <-ch:
+		if v != data.Index {
+			t.Fatalf("unexpected index: got %d, exp %d", v, data.Index)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for index")
+	}
+}
+
+// Ensure an index watch ends when its context is cancelled or the data advances.
+func TestStore_AfterIndexContext(t *testing.T) {
+	t.Parallel()
+	s := MustOpenStore()
+	defer s.Close()
+
+	data, err := s.ReadConsistent()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The watcher closes its channel when it exits after cancellation.
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := s.AfterIndexContext(ctx, data.Index)
+	select {
+	case <-ch:
+		t.Fatal("unexpected close before cancel")
+	default:
+	}
+	cancel()
+	select {
+	case <-ch:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for watcher to exit")
+	}
+
+	// An uncancelled watch is closed once the data advances.
+	ch = s.AfterIndexContext(context.Background(), data.Index)
+	if _, err := s.CreateDatabase("db0"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-ch:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for index")
+	}
+}
+
+// Ensure a waiter is released once the data reaches the index it waits for.
+func TestStore_WaitForIndex(t *testing.T) {
+	t.Parallel()
+	s := MustOpenStore()
+	defer s.Close()
+
+	data, err := s.ReadConsistent()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The current index is returned immediately.
+	if err := s.WaitForIndex(context.Background(), data.Index); err != nil {
+		t.Fatal(err)
+	}
+
+	// A cancelled wait returns the context's error.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := s.WaitForIndex(ctx, data.Index+1); err != context.DeadlineExceeded {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	errc := make(chan error, 1)
+	go func() { errc <- s.WaitForIndex(context.Background(), data.Index+1) }()
+	select {
+	case err := <-errc:
+		t.Fatalf("unexpected return before apply: %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	if _, err := s.CreateDatabase("db0"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for index")
+	}
+}
+
+// Ensure the store reports raft statistics once open.
+func TestStore_Statistics(t *testing.T) {
+	t.Parallel()
+
+	// Statistics are safe to read before the store is open.
+	s := NewStore(NewConfig(MustTempFile()))
+	if stats := s.Statistics(); stats["leader"] != "" || stats["data_index"] != "0" {
+		t.Fatalf("unexpected statistics: %v", stats)
+	}
+
+	s = MustOpenStore()
+	defer s.Close()
+
+	stats := s.Statistics()
+	for _, key := range []string{"applied_index", "commit_index", "last_log_index", "num_peers", "term", "state", "leader", "data_index", "data_term"} {
+		if _, ok := stats[key]; !ok {
+			t.Errorf("missing statistic: %s", key)
+		}
+	}
+	if stats["leader"] != s.Addr.String() {
+		t.Fatalf("unexpected leader: %s", stats["leader"])
+	}
+}
+
+// Ensure the last applied raft index has caught up with the data index and
+// raft's applied_index statistic after applying commands.
+func TestStore_LastAppliedIndex(t *testing.T) {
+	t.Parallel()
+	s := MustOpenStore()
+	defer s.Close()
+
+	for _, name := range []string{"db0", "db1"} {
+		if _, err := s.CreateDatabase(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats := s.Statistics()
+	dataIndex, err := strconv.ParseUint(stats["data_index"], 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	applied, err := strconv.ParseUint(stats["applied_index"], 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx := s.LastAppliedIndex(); dataIndex == 0 || idx < dataIndex {
+		t.Fatalf("unexpected indexes: last applied=%d, data=%d", idx, dataIndex)
+	} else if idx < applied {
+		t.Fatalf("unexpected last applied index: %d < %d", idx, applied)
+	}
+}
+
 // Ensure the store can create a new node.
 func TestStore_CreateNode(t *testing.T) {
 	t.Parallel()
@@ -201,6 +789,129 @@ func TestStore_CreateDatabase(t *testing.T) {
 	}
 }
 
+// Ensure a batch of commands is applied all together or not at all.
+func TestStore_ApplyBatch(t *testing.T) {
+	t.Parallel()
+	c := NewConfig(MustTempFile())
+	c.BatchCommands = true
+	s := NewStore(c)
+	if err := s.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	<-s.Ready()
+
+	createDatabase := MustMarshalCommand(internal.Command_CreateDatabaseCommand, internal.E_CreateDatabaseCommand_Command,
+		&internal.CreateDatabaseCommand{Name: proto.String("db0")})
+	createPolicy := MustMarshalCommand(internal.Command_CreateRetentionPolicyCommand, internal.E_CreateRetentionPolicyCommand_Command,
+		&internal.CreateRetentionPolicyCommand{
+			Database: proto.String("db0"),
+			RetentionPolicy: &internal.RetentionPolicyInfo{
+				Name:               proto.String("rp0"),
+				Duration:           proto.Int64(0),
+				ShardGroupDuration: proto.Int64(int64(7 * 24 * time.Hour)),
+				ReplicaN:           proto.Uint32(1),
+			},
+		})
+
+	// The policy is created on a database that was dropped earlier in the
+	// batch, so the whole batch fails.
+	dropDatabase := MustMarshalCommand(internal.Command_DropDatabaseCommand, internal.E_DropDatabaseCommand_Command,
+		&internal.DropDatabaseCommand{Name: proto.String("db0")})
+	if err := s.ApplyBatch([][]byte{createDatabase, dropDatabase, createPolicy}); err == nil {
+		t.Fatal("expected error")
+	} else if di, err := s.Database("db0"); err != nil {
+		t.Fatal(err)
+	} else if di != nil {
+		t.Fatalf("unexpected database after failed batch: %#v", di)
+	}
+
+	// Invalid commands are rejected before anything is committed.
+	for i, b := range [][]byte{
+		[]byte("garbage"),
+		MustMarshalCommand(internal.Command_RemovePeerCommand, internal.E_RemovePeerCommand_Command,
+			&internal.RemovePeerCommand{ID: proto.Uint64(1), Addr: proto.String("localhost:8088")}),
+	} {
+		if err := s.ApplyBatch([][]byte{createDatabase, b}); !errors.Is(err, meta.ErrInvalidCommand) {
+			t.Fatalf("%d. unexpected error: %v", i, err)
+		}
+	}
+
+	// A valid batch applies every command.
+	if err := s.ApplyBatch([][]byte{createDatabase, createPolicy}); err != nil {
+		t.Fatal(err)
+	} else if rpi, err := s.RetentionPolicy("db0", "rp0"); err != nil {
+		t.Fatal(err)
+	} else if rpi == nil {
+		t.Fatal("expected retention policy")
+	}
+}
+
+// Ensure batches are refused unless batch-commands is enabled.
+func TestStore_ApplyBatch_Disabled(t *testing.T) {
+	t.Parallel()
+	s := MustOpenStore()
+	defer s.Close()
+
+	createDatabase := MustMarshalCommand(internal.Command_CreateDatabaseCommand, internal.E_CreateDatabaseCommand_Command,
+		&internal.CreateDatabaseCommand{Name: proto.String("db0")})
+	if err := s.ApplyBatch([][]byte{createDatabase}); err != meta.ErrBatchDisabled {
+		t.Fatalf("unexpected error: %v", err)
+	} else if di, err := s.Database("db0"); err != nil {
+		t.Fatal(err)
+	} else if di != nil {
+		t.Fatalf("unexpected database: %#v", di)
+	}
+}
+
+// Ensure the store returns the names of its databases in order.
+func TestStore_DatabaseNames(t *testing.T) {
+	t.Parallel()
+	s := MustOpenStore()
+	defer s.Close()
+
+	if names := s.DatabaseNames(); len(names) != 0 {
+		t.Fatalf("unexpected names: %v", names)
+	}
+
+	for _, name := range []string{"db0", "db1"} {
+		if _, err := s.CreateDatabase(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if names := s.DatabaseNames(); !reflect.DeepEqual(names, []string{"db0", "db1"}) {
+		t.Fatalf("unexpected names: %v", names)
+	}
+}
+
+// Ensure only one of many concurrent creates of the same database succeeds.
+func TestStore_CreateDatabase_Concurrent(t *testing.T) {
+	t.Parallel()
+	s := MustOpenStore()
+	defer s.Close()
+
+	const n = 20
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			_, err := s.CreateDatabase("db0")
+			errs <- err
+		}()
+	}
+
+	var succeeded int
+	for i := 0; i < n; i++ {
+		if err := <-errs; err == nil {
+			succeeded++
+		} else if err != meta.ErrDatabaseExists {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if succeeded != 1 {
+		t.Fatalf("unexpected successful creates: %d", succeeded)
+	}
+}
+
 // Ensure the store can delete an existing database.
 func TestStore_DropDatabase(t *testing.T) {
 	t.Parallel()
@@ -412,6 +1123,144 @@ func TestStore_UpdateRetentionPolicy(t *testing.T) {
 	}
 }
 
+// Ensure the store can update a retention policy's shard group duration.
+func TestStore_UpdateRetentionPolicy_ShardGroupDuration(t *testing.T) {
+	t.Parallel()
+	s := MustOpenStore()
+	defer s.Close()
+
+	if _, err := s.CreateDatabase("db0"); err != nil {
+		t.Fatal(err)
+	} else if _, err := s.CreateRetentionPolicy("db0", &meta.RetentionPolicyInfo{Name: "rp0", ReplicaN: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	var rpu meta.RetentionPolicyUpdate
+	rpu.SetDuration(48 * time.Hour)
+	rpu.SetShardGroupDuration(2 * time.Hour)
+	if err := s.UpdateRetentionPolicy("db0", "rp0", &rpu); err != nil {
+		t.Fatal(err)
+	}
+
+	if rpi, err := s.RetentionPolicy("db0", "rp0"); err != nil {
+		t.Fatal(err)
+	} else if rpi.ShardGroupDuration != 2*time.Hour {
+		t.Fatalf("unexpected shard group duration: %s", rpi.ShardGroupDuration)
+	}
+}
+
+// Ensure a retention policy update is validated before it is applied.
+func TestRetentionPolicyUpdate_Validate(t *testing.T) {
+	stringp := func(v string) *string { return &v }
+	durationp := func(v time.Duration) *time.Duration { return &v }
+	intp := func(v int) *int { return &v }
+
+	for i, tt := range []struct {
+		rpu meta.RetentionPolicyUpdate
+		err error
+	}{
+		{rpu: meta.RetentionPolicyUpdate{}},
+		{rpu: meta.RetentionPolicyUpdate{Name: stringp("rp1"), Duration: durationp(48 * time.Hour), ReplicaN: intp(3), ShardGroupDuration: durationp(24 * time.Hour)}},
+		{rpu: meta.RetentionPolicyUpdate{Duration: durationp(0), ShardGroupDuration: durationp(7 * 24 * time.Hour)}},
+		{rpu: meta.RetentionPolicyUpdate{Duration: durationp(meta.MinRetentionPolicyDuration)}},
+		{rpu: meta.RetentionPolicyUpdate{Name: stringp("")}, err: meta.ErrRetentionPolicyNameRequired},
+		{rpu: meta.RetentionPolicyUpdate{Duration: durationp(-time.Hour)}, err: meta.ErrRetentionPolicyDurationTooLow},
+		{rpu: meta.RetentionPolicyUpdate{Duration: durationp(time.Minute)}, err: meta.ErrRetentionPolicyDurationTooLow},
+		{rpu: meta.RetentionPolicyUpdate{ReplicaN: intp(0)}, err: meta.ErrReplicationFactorTooLow},
+		{rpu: meta.RetentionPolicyUpdate{ReplicaN: intp(-1)}, err: meta.ErrReplicationFactorTooLow},
+		{rpu: meta.RetentionPolicyUpdate{ShardGroupDuration: durationp(0)}, err: meta.ErrRetentionPolicyShardGroupDurationInvalid},
+		{rpu: meta.RetentionPolicyUpdate{ShardGroupDuration: durationp(-time.Hour)}, err: meta.ErrRetentionPolicyShardGroupDurationInvalid},
+		{rpu: meta.RetentionPolicyUpdate{Duration: durationp(time.Hour), ShardGroupDuration: durationp(2 * time.Hour)}, err: meta.ErrRetentionPolicyShardGroupDurationTooHigh},
+	} {
+		if err := tt.rpu.Validate(); err != tt.err {
+			t.Errorf("%d. unexpected error: got %v, exp %v", i, err, tt.err)
+		}
+	}
+}
+
+// Ensure a retention policy update is encoded with only the fields that are set.
+func TestRetentionPolicyUpdate_MarshalJSON(t *testing.T) {
+	var rpu meta.RetentionPolicyUpdate
+	if b, err := json.Marshal(&rpu); err != nil {
+		t.Fatal(err)
+	} else if string(b) != `{}` {
+		t.Fatalf("unexpected json: %s", b)
+	}
+
+	rpu.SetName("rp1")
+	rpu.SetDuration(72 * time.Hour)
+	rpu.SetReplicaN(2)
+	rpu.SetShardGroupDuration(24 * time.Hour)
+	b, err := json.Marshal(&rpu)
+	if err != nil {
+		t.Fatal(err)
+	} else if string(b) != `{"name":"rp1","duration":"72h0m0s","replicaN":2,"shardGroupDuration":"24h0m0s"}` {
+		t.Fatalf("unexpected json: %s", b)
+	}
+
+	var other meta.RetentionPolicyUpdate
+	if err := json.Unmarshal(b, &other); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(other, rpu) {
+		t.Fatalf("unexpected round trip: %#v", other)
+	}
+}
+
+// Ensure omitted and null fields are left unset when decoding a retention policy update.
+func TestRetentionPolicyUpdate_UnmarshalJSON(t *testing.T) {
+	stringp := func(v string) *string { return &v }
+	durationp := func(v time.Duration) *time.Duration { return &v }
+	intp := func(v int) *int { return &v }
+
+	for i, tt := range []struct {
+		s   string
+		rpu meta.RetentionPolicyUpdate
+		err string
+	}{
+		{s: `{}`},
+		{s: `{"name":null,"duration":null,"replicaN":null,"shardGroupDuration":null}`},
+		{s: `{"duration":"72h"}`, rpu: meta.RetentionPolicyUpdate{Duration: durationp(72 * time.Hour)}},
+		{s: `{"duration":"3d","shardGroupDuration":"1h30m"}`, rpu: meta.RetentionPolicyUpdate{Duration: durationp(72 * time.Hour), ShardGroupDuration: durationp(90 * time.Minute)}},
+		{s: `{"name":"rp1","replicaN":0}`, rpu: meta.RetentionPolicyUpdate{Name: stringp("rp1"), ReplicaN: intp(0)}},
+		{s: `{"duration":"0s"}`, rpu: meta.RetentionPolicyUpdate{Duration: durationp(0)}},
+		{s: `{"duration":"forever"}`, err: `invalid duration: "forever" is not a duration`},
+		{s: `{"shardGroupDuration":"1x"}`, err: `invalid shard group duration: "1x" is not a duration`},
+	} {
+		var rpu meta.RetentionPolicyUpdate
+		err := json.Unmarshal([]byte(tt.s), &rpu)
+		if err != nil {
+			if err.Error() != tt.err {
+				t.Errorf("%d. %s: unexpected error: got %v, exp %s", i, tt.s, err, tt.err)
+			}
+		} else if tt.err != "" {
+			t.Errorf("%d. %s: expected error: %s", i, tt.s, tt.err)
+		} else if !reflect.DeepEqual(rpu, tt.rpu) {
+			t.Errorf("%d. %s: unexpected update: %#v", i, tt.s, rpu)
+		}
+	}
+}
+
+// Ensure an invalid retention policy update is rejected by the store.
+func TestStore_UpdateRetentionPolicy_Invalid(t *testing.T) {
+	t.Parallel()
+	s := MustOpenStore()
+	defer s.Close()
+
+	if _, err := s.CreateDatabase("db0"); err != nil {
+		t.Fatal(err)
+	} else if _, err := s.CreateRetentionPolicy("db0", &meta.RetentionPolicyInfo{Name: "rp0", ReplicaN: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	var rpu meta.RetentionPolicyUpdate
+	rpu.SetReplicaN(0)
+	if err := s.UpdateRetentionPolicy("db0", "rp0", &rpu); err != meta.ErrReplicationFactorTooLow {
+		t.Fatalf("unexpected error: %v", err)
+	} else if rpi, _ := s.RetentionPolicy("db0", "rp0"); rpi.ReplicaN != 1 {
+		t.Fatalf("unexpected replica n: %d", rpi.ReplicaN)
+	}
+}
+
 // Ensure the store can create a shard group on a retention policy.
 func TestStore_CreateShardGroup(t *testing.T) {
 	t.Parallel()
@@ -750,6 +1599,37 @@ func TestStore_CreateUser(t *testing.T) {
 	}
 }
 
+// Ensure users are listed without their password hashes.
+func TestStore_Users(t *testing.T) {
+	t.Parallel()
+	s := MustOpenStore()
+	defer s.Close()
+
+	if _, err := s.CreateUser("susy", "pass", false); err != nil {
+		t.Fatal(err)
+	} else if err := s.SetPrivilege("susy", "db0", influxql.ReadPrivilege); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := s.Users()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(a) != 1 || a[0].Name != "susy" {
+		t.Fatalf("unexpected users: %+v", a)
+	} else if a[0].Hash != "" {
+		t.Fatalf("hash not removed: %q", a[0].Hash)
+	} else if a[0].Privileges["db0"] != influxql.ReadPrivilege {
+		t.Fatalf("unexpected privileges: %v", a[0].Privileges)
+	}
+
+	// The stored user keeps its hash.
+	if ui, err := s.User("susy"); err != nil {
+		t.Fatal(err)
+	} else if ui.Hash == "" {
+		t.Fatal("stored hash removed")
+	}
+}
+
 // Ensure the store can remove a user.
 func TestStore_DropUser(t *testing.T) {
 	t.Parallel()
@@ -884,6 +1764,43 @@ func TestStore_UserCount(t *testing.T) {
 	}
 }
 
+// Ensure snapshot callbacks are called with the snapshot's index.
+func TestStore_OnSnapshot(t *testing.T) {
+	t.Parallel()
+	s := MustOpenStore()
+	defer s.Close()
+
+	if _, err := s.CreateDatabase("db0"); err != nil {
+		t.Fatal(err)
+	}
+
+	indexes := make(chan uint64, 1)
+	s.OnSnapshot(func(index uint64) {
+		// The store lock is not held so reads must not block.
+		if _, err := s.Databases(); err != nil {
+			t.Error(err)
+		}
+		indexes <- index
+	})
+
+	if err := s.Store.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := s.ReadConsistent()
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case index := <-indexes:
+		if index != data.Index {
+			t.Fatalf("unexpected index: got %d, exp %d", index, data.Index)
+		}
+	default:
+		t.Fatal("callback not called")
+	}
+}
+
 // Ensure the store can take a snapshot.
 func TestStore_Snapshot_And_Restore(t *testing.T) {
 	t.Parallel()
@@ -933,6 +1850,206 @@ func TestStore_Snapshot_And_Restore(t *testing.T) {
 	}
 }
 
+// Ensure applied commands are recorded in the apply latency histogram.
+func TestStore_Statistics_ApplyDuration(t *testing.T) {
+	t.Parallel()
+	s := MustOpenStore()
+	defer s.Close()
+
+	for n := 0; n < 3; n++ {
+		if _, err := s.CreateDatabase(fmt.Sprintf("db%d", n)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats := s.Statistics()
+	count, _ := strconv.Atoi(stats["apply_duration_count"])
+	if count < 3 {
+		t.Fatalf("unexpected apply count: %d", count)
+	} else if stats["apply_duration_le_inf"] != stats["apply_duration_count"] {
+		t.Fatalf("unexpected inf bucket: %s", stats["apply_duration_le_inf"])
+	} else if _, ok := stats["apply_duration_le_5s"]; !ok {
+		t.Fatalf("expected default buckets: %v", stats)
+	}
+}
+
+// Ensure a forced snapshot advances the raft snapshot index.
+func TestStore_ForceSnapshot(t *testing.T) {
+	t.Parallel()
+	s := MustOpenStore()
+	defer s.Close()
+
+	if _, err := s.CreateDatabase("db0"); err != nil {
+		t.Fatal(err)
+	} else if err := s.ForceSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+	index, _ := strconv.ParseUint(s.Statistics()["last_snapshot_index"], 10, 64)
+	if index == 0 {
+		t.Fatal("expected a snapshot index")
+	}
+
+	if _, err := s.CreateDatabase("db1"); err != nil {
+		t.Fatal(err)
+	} else if err := s.ForceSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+	if other, _ := strconv.ParseUint(s.Statistics()["last_snapshot_index"], 10, 64); other <= index {
+		t.Fatalf("snapshot index not advanced: %d <= %d", other, index)
+	}
+}
+
+// Ensure forcing a snapshot on a closed store returns an error.
+func TestStore_ForceSnapshot_Closed(t *testing.T) {
+	t.Parallel()
+	s := MustOpenStore()
+	s.Close()
+
+	if err := s.ForceSnapshot(); err != meta.ErrStoreClosed {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure the store can write a snapshot that matches its encoded data.
+func TestStore_SnapshotTo(t *testing.T) {
+	t.Parallel()
+	s := MustOpenStore()
+	defer s.Close()
+
+	for n := 0; n < 5; n++ {
+		if _, err := s.CreateDatabase(fmt.Sprintf("db%d", n)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := s.SnapshotTo(&buf, meta.SnapshotCompressionNone); err != nil {
+		t.Fatal(err)
+	}
+
+	// Ensure the snapshot decodes.
+	var data meta.Data
+	if err := data.UnmarshalBinary(buf.Bytes()); err != nil {
+		t.Fatal(err)
+	} else if len(data.Databases) != 5 {
+		t.Fatalf("unexpected database count: %d", len(data.Databases))
+	}
+
+	// Compare against encoding a clone of the data.
+	if exp, err := s.MarshalBinary(); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(buf.Bytes(), exp) {
+		t.Fatal("snapshot does not match encoded data")
+	} else if b, err := data.Clone().MarshalBinary(); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(buf.Bytes(), b) {
+		t.Fatal("snapshot does not match encoded clone")
+	}
+
+	// A gzip snapshot decompresses to the same bytes.
+	var gz bytes.Buffer
+	if err := s.SnapshotTo(&gz, meta.SnapshotCompressionGzip); err != nil {
+		t.Fatal(err)
+	}
+	r, err := gzip.NewReader(&gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadAll(r); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(b, buf.Bytes()) {
+		t.Fatal("gzip snapshot does not match encoded data")
+	}
+
+	// An unknown compression is refused.
+	if err := s.SnapshotTo(ioutil.Discard, "lz4"); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+// Ensure a snapshot of one store can be restored into a fresh store.
+func TestStore_Restore(t *testing.T) {
+	t.Parallel()
+	s0 := MustOpenStore()
+	defer s0.Close()
+
+	if _, err := s0.CreateDatabase("db0"); err != nil {
+		t.Fatal(err)
+	} else if _, err := s0.CreateRetentionPolicy("db0", &meta.RetentionPolicyInfo{Name: "rp0", ReplicaN: 1}); err != nil {
+		t.Fatal(err)
+	} else if _, err := s0.CreateUser("susy", "pass", true); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := s0.SnapshotTo(&buf, meta.SnapshotCompressionNone); err != nil {
+		t.Fatal(err)
+	}
+	var data meta.Data
+	if err := data.UnmarshalBinary(buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+
+	s1 := MustOpenStore()
+	defer s1.Close()
+	if err := s1.Restore(&data, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Ensure the restored store matches the original.
+	if exp, err := s0.Databases(); err != nil {
+		t.Fatal(err)
+	} else if got, err := s1.Databases(); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(got, exp) {
+		t.Fatalf("unexpected databases: %#v", got)
+	}
+	if exp, err := s0.User("susy"); err != nil {
+		t.Fatal(err)
+	} else if got, err := s1.User("susy"); err != nil {
+		t.Fatal(err)
+	} else if got.Hash != exp.Hash || got.Admin != exp.Admin {
+		t.Fatalf("unexpected user: %#v", got)
+	}
+
+	// The store now holds data so another restore must be forced.
+	if err := s1.Restore(&data, false); err != meta.ErrStoreNotEmpty {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := s1.Restore(&data, true); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure a snapshot is not restored onto a member of a cluster unless forced.
+func TestCluster_Restore_ErrStoreInCluster(t *testing.T) {
+	c := MustOpenCluster(3)
+	defer c.Close()
+
+	leader := c.Leader()
+	if leader == nil {
+		t.Fatal("no leader found")
+	}
+
+	data := &meta.Data{}
+	if err := data.CreateDatabase("db0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := leader.Restore(data, false); err != meta.ErrStoreInCluster {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := leader.Restore(data, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// Ensure followers converge on the restored data.
+	for _, s := range c.Stores {
+		if data, err := s.ReadConsistent(); err != nil {
+			t.Fatal(err)
+		} else if data.Database("db0") == nil {
+			t.Fatalf("database not restored on %s", s.Addr.String())
+		}
+	}
+}
+
 // Ensure a multi-node cluster can start, join the cluster, and replicate commands.
 func TestCluster_Open(t *testing.T) {
 	c := MustOpenCluster(3)
@@ -947,6 +2064,84 @@ func TestCluster_Open(t *testing.T) {
 	assertDatabaseReplicated(t, c)
 }
 
+// Ensure every member of a cluster lists all raft peers.
+func TestCluster_Peers(t *testing.T) {
+	c := MustOpenCluster(3)
+	defer c.Close()
+
+	var exp []string
+	for _, s := range c.Stores {
+		exp = append(exp, s.RemoteAddr.String())
+	}
+	sort.Strings(exp)
+
+	for _, s := range c.Stores {
+		peers, err := s.Peers()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(peers)
+		if !reflect.DeepEqual(peers, exp) {
+			t.Fatalf("unexpected peers on %s: %v", s.RemoteAddr, peers)
+		}
+	}
+
+	// A closed store has no raft state to read peers from.
+	s := c.Stores[0]
+	s.Close()
+	if _, err := s.Peers(); err != meta.ErrStoreClosed {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure a consistent read on a follower reflects a change just committed on the leader.
+func TestCluster_ReadConsistent(t *testing.T) {
+	c := MustOpenCluster(3)
+	defer c.Close()
+
+	leader := c.Leader()
+	if leader == nil {
+		t.Fatal("no leader found")
+	}
+	if _, err := leader.CreateDatabase("db0"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range c.Stores {
+		data, err := s.ReadConsistent()
+		if err != nil {
+			t.Fatalf("read on %s: %s", s.Addr.String(), err)
+		} else if data.Database("db0") == nil {
+			t.Fatalf("read on %s: database not found", s.Addr.String())
+		}
+	}
+}
+
+// Ensure a command returns a timeout error when the quorum is lost.
+func TestCluster_ApplyTimeout(t *testing.T) {
+	c := MustOpenCluster(3)
+	defer c.Close()
+
+	leader := MustStallCluster(c)
+	leader.ApplyTimeout = 50 * time.Millisecond
+	if _, err := leader.CreateDatabase("db0"); err != meta.ErrApplyTimeout {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure a command returns a distinct error when the leader steps down
+// before the command is committed.
+func TestCluster_ApplyLeadershipLost(t *testing.T) {
+	c := MustOpenCluster(3)
+	defer c.Close()
+
+	leader := MustStallCluster(c)
+	leader.ApplyTimeout = 10 * time.Second
+	if _, err := leader.CreateDatabase("db0"); err != meta.ErrLeadershipLost {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
 // Ensure a multi-node cluster can start, join the cluster, and the first three members are raft nodes.
 func TestCluster_OpenRaft(t *testing.T) {
 	t.Skip()
@@ -1153,6 +2348,19 @@ func NewStore(c *meta.Config) *Store {
 	return s
 }
 
+// MustMarshalCommand encodes a command of type typ holding value. Panic on error.
+func MustMarshalCommand(typ internal.Command_Type, desc *proto.ExtensionDesc, value interface{}) []byte {
+	cmd := &internal.Command{Type: &typ}
+	if err := proto.SetExtension(cmd, desc, value); err != nil {
+		panic(err)
+	}
+	b, err := proto.Marshal(cmd)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
 // MustOpenStore opens a store in a temporary path. Panic on error.
 func MustOpenStore() *Store {
 	return MustOpenStoreWithPath("", MustTempFile())
@@ -1354,6 +2562,21 @@ func (c *Cluster) Leader() *Store {
 	return nil
 }
 
+// MustStallCluster closes every store except the leader so that no commands
+// can be committed. Returns the leader.
+func MustStallCluster(c *Cluster) *Store {
+	leader := c.Leader()
+	if leader == nil {
+		panic("no leader found")
+	}
+	for _, s := range c.Stores {
+		if s != leader {
+			s.Close()
+		}
+	}
+	return leader
+}
+
 // MustTempFile returns the path to a non-existent temporary file.
 func MustTempFile() string {
 	f, _ := ioutil.TempFile("", "influxdb-meta-")
@@ -1,15 +1,81 @@
 package httpd
 
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"time"
+
+	"github.com/influxdb/influxdb/toml"
+)
+
+const (
+	// DefaultShutdownTimeout is the default time to wait for in-flight
+	// requests to complete when the service is closed.
+	DefaultShutdownTimeout = 10 * time.Second
+
+	// DefaultReadTimeout is the default time limit for reading a request,
+	// including its body. It is disabled by default because large write
+	// batches from slow clients can take longer than any fixed limit.
+	DefaultReadTimeout = time.Duration(0)
+
+	// DefaultWriteTimeout is the default time limit for writing a response.
+	// It is disabled by default because query results can stream for longer
+	// than any fixed limit.
+	DefaultWriteTimeout = time.Duration(0)
+
+	// DefaultIdleTimeout is the default time an idle keep-alive connection
+	// is kept open.
+	DefaultIdleTimeout = 2 * time.Minute
+
+	// DefaultMaxBodySize is the default limit on the size of a request body.
+	// It is disabled by default so that large write batches keep working.
+	DefaultMaxBodySize = 0
+
+	// DefaultBindNetwork is the default network to bind to.
+	DefaultBindNetwork = "tcp"
+
+	// DefaultTLSMinVersion is the default minimum TLS version for HTTPS.
+	DefaultTLSMinVersion = "1.2"
+)
+
+// tlsVersions maps configuration names to TLS versions.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
 // Config represents a configuration for a HTTP service.
 type Config struct {
-	Enabled          bool   `toml:"enabled"`
-	BindAddress      string `toml:"bind-address"`
-	AuthEnabled      bool   `toml:"auth-enabled"`
-	LogEnabled       bool   `toml:"log-enabled"`
-	WriteTracing     bool   `toml:"write-tracing"`
-	PprofEnabled     bool   `toml:"pprof-enabled"`
-	HTTPSEnabled     bool   `toml:"https-enabled"`
-	HTTPSCertificate string `toml:"https-certificate"`
+	Enabled                bool          `toml:"enabled"`
+	BindAddress            string        `toml:"bind-address"`
+	BindNetwork            string        `toml:"bind-network"`
+	AuthEnabled            bool          `toml:"auth-enabled"`
+	LogEnabled             bool          `toml:"log-enabled"`
+	WriteTracing           bool          `toml:"write-tracing"`
+	PprofEnabled           bool          `toml:"pprof-enabled"`
+	DataEnabled            bool          `toml:"data-enabled"`
+	SnapshotEnabled        bool          `toml:"snapshot-enabled"`
+	HTTPSEnabled           bool          `toml:"https-enabled"`
+	HTTPSCertificate       string        `toml:"https-certificate"`
+	HTTPSPrivateKey        string        `toml:"https-private-key"`
+	TLSMinVersion          string        `toml:"tls-min-version"`
+	TLSCiphers             []string      `toml:"tls-ciphers"`
+	HTTPSClientCA          string        `toml:"https-client-ca"`
+	HTTPSRequireClientCert bool          `toml:"https-require-client-cert"`
+	ShutdownTimeout        toml.Duration `toml:"shutdown-timeout"`
+	ReadTimeout            toml.Duration `toml:"read-timeout"`
+	WriteTimeout           toml.Duration `toml:"write-timeout"`
+	IdleTimeout            toml.Duration `toml:"idle-timeout"`
+	MaxConnections         int           `toml:"max-connections"`
+	MaxBodySize            int           `toml:"max-body-size"`
+	CORSAllowedOrigins     []string      `toml:"cors-allowed-origins"`
+	CORSAllowedMethods     []string      `toml:"cors-allowed-methods"`
 }
 
 // NewConfig returns a new Config with default settings.
@@ -17,8 +83,139 @@ func NewConfig() Config {
 	return Config{
 		Enabled:          true,
 		BindAddress:      ":8086",
+		BindNetwork:      DefaultBindNetwork,
 		LogEnabled:       true,
 		HTTPSEnabled:     false,
 		HTTPSCertificate: "/etc/ssl/influxdb.pem",
+		ShutdownTimeout:  toml.Duration(DefaultShutdownTimeout),
+		ReadTimeout:      toml.Duration(DefaultReadTimeout),
+		WriteTimeout:     toml.Duration(DefaultWriteTimeout),
+		IdleTimeout:      toml.Duration(DefaultIdleTimeout),
+		TLSMinVersion:    DefaultTLSMinVersion,
+		MaxBodySize:      DefaultMaxBodySize,
+	}
+}
+
+// Validate returns an error if the config is invalid.
+func (c Config) Validate() error {
+	if c.Enabled && c.HTTPSEnabled {
+		if _, err := loadCertificate(c.HTTPSCertificate, c.HTTPSPrivateKey); err != nil {
+			return fmt.Errorf("HTTP.HTTPSCertificate: %s", err)
+		}
+		if c.HTTPSRequireClientCert && c.HTTPSClientCA == "" {
+			return errors.New("HTTP.HTTPSClientCA must be specified to require client certificates")
+		}
+		if c.HTTPSClientCA != "" {
+			if _, err := loadCertPool(c.HTTPSClientCA); err != nil {
+				return fmt.Errorf("HTTP.HTTPSClientCA: %s", err)
+			}
+		}
+	}
+	switch c.BindNetwork {
+	case "", "tcp", "tcp4", "tcp6":
+	default:
+		return fmt.Errorf("HTTP.BindNetwork must be one of tcp, tcp4 or tcp6, got %s", c.BindNetwork)
+	}
+	if c.ReadTimeout < 0 {
+		return errors.New("HTTP.ReadTimeout must not be negative")
+	} else if c.WriteTimeout < 0 {
+		return errors.New("HTTP.WriteTimeout must not be negative")
+	} else if c.IdleTimeout < 0 {
+		return errors.New("HTTP.IdleTimeout must not be negative")
+	} else if c.MaxConnections < 0 {
+		return errors.New("HTTP.MaxConnections must not be negative")
+	} else if c.MaxBodySize < 0 {
+		return errors.New("HTTP.MaxBodySize must not be negative")
+	}
+	if _, err := tlsVersion(c.TLSMinVersion); err != nil {
+		return err
+	}
+	if _, err := tlsCipherSuites(c.TLSCiphers); err != nil {
+		return err
+	}
+	return nil
+}
+
+// reloadableFields names the Config fields that Service.Reload can change.
+// The timeouts are read by the running http.Server without locking, so
+// they need a restart.
+var reloadableFields = map[string]bool{
+	"LogEnabled":   true,
+	"WriteTracing": true,
+}
+
+// checkReload returns an error naming the first field, other than those
+// Service.Reload can change, that differs between prev and next.
+func checkReload(prev, next Config) error {
+	pv, nv := reflect.ValueOf(prev), reflect.ValueOf(next)
+	t := pv.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if reloadableFields[f.Name] {
+			continue
+		}
+		if !reflect.DeepEqual(pv.Field(i).Interface(), nv.Field(i).Interface()) {
+			return fmt.Errorf("HTTP.%s cannot be changed without a restart", f.Name)
+		}
+	}
+	return nil
+}
+
+// loadCertificate loads a certificate and private key. The private key is
+// read from the certificate file if key is empty.
+func loadCertificate(cert, key string) (tls.Certificate, error) {
+	if key == "" {
+		key = cert
+	}
+	return tls.LoadX509KeyPair(cert, key)
+}
+
+// loadCertPool returns a pool of the PEM encoded certificates in path.
+func loadCertPool(path string) (*x509.CertPool, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return pool, nil
+}
+
+// tlsVersion returns the TLS version for name. An empty name returns zero,
+// which leaves the Go default in place.
+func tlsVersion(name string) (uint16, error) {
+	if name == "" {
+		return 0, nil
+	}
+	v, ok := tlsVersions[name]
+	if !ok {
+		return 0, fmt.Errorf("unrecognized tls version %s", name)
+	}
+	return v, nil
+}
+
+// tlsCipherSuites returns the cipher suite IDs for names. No names returns
+// nil, which leaves the Go defaults in place.
+func tlsCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	suites := make(map[string]uint16)
+	for _, cs := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		suites[cs.Name] = cs.ID
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := suites[name]
+		if !ok {
+			return nil, fmt.Errorf("unrecognized tls cipher %s", name)
+		}
+		ids = append(ids, id)
 	}
+	return ids, nil
 }
@@ -1,7 +1,10 @@
 package httpd_test
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/influxdb/influxdb/services/httpd"
@@ -13,12 +16,28 @@ func TestConfig_Parse(t *testing.T) {
 	if _, err := toml.Decode(`
 enabled = true
 bind-address = ":8080"
+bind-network = "tcp4"
 auth-enabled = true
 log-enabled = true
 write-tracing = true
 pprof-enabled = true
+data-enabled = true
+snapshot-enabled = true
 https-enabled = true
 https-certificate = "/dev/null"
+https-private-key = "/dev/zero"
+tls-min-version = "1.3"
+tls-ciphers = ["TLS_AES_128_GCM_SHA256"]
+https-client-ca = "/dev/random"
+https-require-client-cert = true
+shutdown-timeout = "30s"
+read-timeout = "5s"
+write-timeout = "10s"
+idle-timeout = "1m"
+max-connections = 100
+max-body-size = 1024
+cors-allowed-origins = ["https://admin.example.com"]
+cors-allowed-methods = ["GET", "POST"]
 `, &c); err != nil {
 		t.Fatal(err)
 	}
@@ -28,6 +47,8 @@ https-certificate = "/dev/null"
 		t.Fatalf("unexpected enabled: %v", c.Enabled)
 	} else if c.BindAddress != ":8080" {
 		t.Fatalf("unexpected bind address: %s", c.BindAddress)
+	} else if c.BindNetwork != "tcp4" {
+		t.Fatalf("unexpected bind network: %s", c.BindNetwork)
 	} else if c.AuthEnabled != true {
 		t.Fatalf("unexpected auth enabled: %v", c.AuthEnabled)
 	} else if c.LogEnabled != true {
@@ -36,10 +57,112 @@ https-certificate = "/dev/null"
 		t.Fatalf("unexpected write tracing: %v", c.WriteTracing)
 	} else if c.PprofEnabled != true {
 		t.Fatalf("unexpected pprof enabled: %v", c.PprofEnabled)
+	} else if c.DataEnabled != true {
+		t.Fatalf("unexpected data enabled: %v", c.DataEnabled)
+	} else if c.SnapshotEnabled != true {
+		t.Fatalf("unexpected snapshot enabled: %v", c.SnapshotEnabled)
 	} else if c.HTTPSEnabled != true {
 		t.Fatalf("unexpected https enabled: %v", c.HTTPSEnabled)
 	} else if c.HTTPSCertificate != "/dev/null" {
 		t.Fatalf("unexpected https certificate: %v", c.HTTPSCertificate)
+	} else if c.HTTPSPrivateKey != "/dev/zero" {
+		t.Fatalf("unexpected https private key: %v", c.HTTPSPrivateKey)
+	} else if c.TLSMinVersion != "1.3" {
+		t.Fatalf("unexpected tls min version: %v", c.TLSMinVersion)
+	} else if len(c.TLSCiphers) != 1 || c.TLSCiphers[0] != "TLS_AES_128_GCM_SHA256" {
+		t.Fatalf("unexpected tls ciphers: %v", c.TLSCiphers)
+	} else if c.HTTPSClientCA != "/dev/random" {
+		t.Fatalf("unexpected https client ca: %v", c.HTTPSClientCA)
+	} else if !c.HTTPSRequireClientCert {
+		t.Fatalf("unexpected https require client cert: %v", c.HTTPSRequireClientCert)
+	} else if time.Duration(c.ShutdownTimeout) != 30*time.Second {
+		t.Fatalf("unexpected shutdown timeout: %v", c.ShutdownTimeout)
+	} else if time.Duration(c.ReadTimeout) != 5*time.Second {
+		t.Fatalf("unexpected read timeout: %v", c.ReadTimeout)
+	} else if time.Duration(c.WriteTimeout) != 10*time.Second {
+		t.Fatalf("unexpected write timeout: %v", c.WriteTimeout)
+	} else if time.Duration(c.IdleTimeout) != time.Minute {
+		t.Fatalf("unexpected idle timeout: %v", c.IdleTimeout)
+	} else if c.MaxConnections != 100 {
+		t.Fatalf("unexpected max connections: %v", c.MaxConnections)
+	} else if c.MaxBodySize != 1024 {
+		t.Fatalf("unexpected max body size: %v", c.MaxBodySize)
+	} else if len(c.CORSAllowedOrigins) != 1 || c.CORSAllowedOrigins[0] != "https://admin.example.com" {
+		t.Fatalf("unexpected cors allowed origins: %v", c.CORSAllowedOrigins)
+	} else if len(c.CORSAllowedMethods) != 2 || c.CORSAllowedMethods[1] != "POST" {
+		t.Fatalf("unexpected cors allowed methods: %v", c.CORSAllowedMethods)
+	}
+}
+
+// Ensure the HTTPS certificate can be combined with or separate from the key.
+func TestConfig_Validate_HTTPSCertificate(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	for _, combined := range []bool{true, false} {
+		c := httpd.NewConfig()
+		c.HTTPSEnabled = true
+		c.HTTPSCertificate, c.HTTPSPrivateKey = MustWriteCertificate(dir, combined)
+		if err := c.Validate(); err != nil {
+			t.Fatalf("combined=%v: unexpected error: %s", combined, err)
+		}
+	}
+
+	// A certificate without a key must be rejected.
+	c := httpd.NewConfig()
+	c.HTTPSEnabled = true
+	c.HTTPSCertificate, _ = MustWriteCertificate(dir, false)
+	if err := c.Validate(); err == nil {
+		t.Fatal("expected error for missing private key")
+	}
+
+	// A missing certificate must be rejected.
+	c.HTTPSCertificate = filepath.Join(dir, "missing.pem")
+	if err := c.Validate(); err == nil {
+		t.Fatal("expected error for missing certificate")
+	}
+}
+
+// Ensure unknown TLS versions and ciphers are rejected.
+func TestConfig_Validate_TLS(t *testing.T) {
+	c := httpd.NewConfig()
+	c.TLSCiphers = []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}
+	if err := c.Validate(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	c.TLSMinVersion = "1.4"
+	if err := c.Validate(); err == nil {
+		t.Fatal("expected error for unknown tls version")
+	}
+
+	c.TLSMinVersion = "1.2"
+	c.TLSCiphers = []string{"TLS_NULL"}
+	if err := c.Validate(); err == nil {
+		t.Fatal("expected error for unknown tls cipher")
+	}
+}
+
+// Ensure negative timeouts and limits and unknown networks are rejected.
+func TestConfig_Validate_Timeouts(t *testing.T) {
+	c := httpd.NewConfig()
+	if err := c.Validate(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	for _, fn := range []func(c *httpd.Config){
+		func(c *httpd.Config) { c.ReadTimeout = -1 },
+		func(c *httpd.Config) { c.WriteTimeout = -1 },
+		func(c *httpd.Config) { c.IdleTimeout = -1 },
+		func(c *httpd.Config) { c.MaxConnections = -1 },
+		func(c *httpd.Config) { c.MaxBodySize = -1 },
+		func(c *httpd.Config) { c.BindNetwork = "udp" },
+	} {
+		c := httpd.NewConfig()
+		fn(&c)
+		if err := c.Validate(); err == nil {
+			t.Fatalf("expected error for config: %#v", c)
+		}
 	}
 }
 
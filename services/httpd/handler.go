@@ -3,6 +3,7 @@ package httpd
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"encoding/json"
 	"errors"
 	"expvar"
@@ -15,6 +16,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/bmizerany/pat"
@@ -62,6 +64,8 @@ type Handler struct {
 		Database(name string) (*meta.DatabaseInfo, error)
 		Authenticate(username, password string) (ui *meta.UserInfo, err error)
 		Users() ([]meta.UserInfo, error)
+		ReadConsistent() (*meta.Data, error)
+		ForceSnapshot() error
 	}
 
 	QueryExecutor interface {
@@ -75,16 +79,29 @@ type Handler struct {
 
 	ContinuousQuerier continuous_querier.ContinuousQuerier
 
-	Logger         *log.Logger
-	loggingEnabled bool // Log every HTTP access.
-	WriteTrace     bool // Detailed logging of write path
+	Logger          *log.Logger
+	loggingEnabled  bool // Log every HTTP access.
+	WriteTrace      bool // Detailed logging of write path
+	PprofEnabled    bool // Serve profiles under /debug/pprof
+	DataEnabled     bool // Serve the meta data as JSON under /data
+	SnapshotEnabled bool // Force raft snapshots with POST /data/snapshot
+	MaxBodySize     int  // Largest request body accepted in bytes; 0 is unlimited
+
+	// Cross-origin requests are allowed from AllowedOrigins using
+	// AllowedMethods. No origins allows all; no methods uses the defaults.
+	AllowedOrigins []string
+	AllowedMethods []string
 	statMap        *expvar.Map
+
+	// settingsMu guards loggingEnabled and WriteTrace, which SetLogging
+	// changes while requests are served.
+	settingsMu sync.RWMutex
 }
 
 // NewHandler returns a new instance of handler with routes.
 func NewHandler(requireAuthentication, loggingEnabled, writeTrace bool, statMap *expvar.Map) *Handler {
 	h := &Handler{
-		mux: pat.New(),
+		mux:                   pat.New(),
 		requireAuthentication: requireAuthentication,
 		Logger:                log.New(os.Stderr, "[http] ", log.LstdFlags),
 		loggingEnabled:        loggingEnabled,
@@ -117,6 +134,14 @@ func NewHandler(requireAuthentication, loggingEnabled, writeTrace bool, statMap
 			"ping-head",
 			"HEAD", "/ping", true, true, h.servePing,
 		},
+		route{ // Dump the meta data for inspection
+			"data",
+			"GET", "/data", true, true, h.serveData,
+		},
+		route{ // Snapshot the raft log to compact it
+			"snapshot",
+			"POST", "/data/snapshot", false, true, h.serveSnapshot,
+		},
 		route{ // Tell data node to run CQs that should be run
 			"process_continuous_queries",
 			"POST", "/data/process_continuous_queries", false, false, h.serveProcessContinuousQueries,
@@ -143,24 +168,40 @@ func (h *Handler) SetRoutes(routes []route) {
 		if r.gzipped {
 			handler = gzipFilter(handler)
 		}
+		handler = limitBody(handler, h)
 		handler = versionHeader(handler, h)
-		handler = cors(handler)
+		handler = cors(handler, h)
 		handler = requestID(handler)
-		if h.loggingEnabled && r.log {
-			handler = logging(handler, r.name, h.Logger)
+		if r.log {
+			handler = logging(handler, r.name, h)
 		}
-		handler = recovery(handler, r.name, h.Logger) // make sure recovery is always last
+		handler = recovery(handler, r.name, h) // make sure recovery is always last
 
 		h.mux.Add(r.method, r.pattern, handler)
 	}
 }
 
+// SetLogging turns access logging and write tracing on or off. It is safe
+// to call while the handler is serving requests.
+func (h *Handler) SetLogging(loggingEnabled, writeTrace bool) {
+	h.settingsMu.Lock()
+	defer h.settingsMu.Unlock()
+	h.loggingEnabled = loggingEnabled
+	h.WriteTrace = writeTrace
+}
+
+// logSettings returns whether access logging and write tracing are enabled.
+func (h *Handler) logSettings() (loggingEnabled, writeTrace bool) {
+	h.settingsMu.RLock()
+	defer h.settingsMu.RUnlock()
+	return h.loggingEnabled, h.WriteTrace
+}
+
 // ServeHTTP responds to HTTP request to the handler.
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	h.statMap.Add(statRequest, 1)
 
-	// FIXME(benbjohnson): Add pprof enabled flag.
-	if strings.HasPrefix(r.URL.Path, "/debug/pprof") {
+	if h.PprofEnabled && strings.HasPrefix(r.URL.Path, "/debug/pprof") {
 		switch r.URL.Path {
 		case "/debug/pprof/cmdline":
 			pprof.Cmdline(w, r)
@@ -370,20 +411,30 @@ func (h *Handler) serveWrite(w http.ResponseWriter, r *http.Request, user *meta.
 			return
 		}
 		body = b
+
+		// Apply the limit to the decoded body too.
+		if h.MaxBodySize > 0 {
+			body = http.MaxBytesReader(w, body, int64(h.MaxBodySize))
+		}
 	}
 	defer body.Close()
 
+	_, writeTrace := h.logSettings()
 	b, err := ioutil.ReadAll(body)
 	if err != nil {
-		if h.WriteTrace {
-			h.Logger.Print("write handler unable to read bytes from request body")
+		if writeTrace {
+			h.Logger.Printf("[%s] write handler unable to read bytes from request body", requestIDFromContext(r.Context()))
+		}
+		if isMaxBytesError(err) {
+			resultError(w, influxql.Result{Err: err}, http.StatusRequestEntityTooLarge)
+			return
 		}
 		resultError(w, influxql.Result{Err: err}, http.StatusBadRequest)
 		return
 	}
 	h.statMap.Add(statWriteRequestBytesReceived, int64(len(b)))
-	if h.WriteTrace {
-		h.Logger.Printf("write body received by handler: %s", string(b))
+	if writeTrace {
+		h.Logger.Printf("[%s] write body received by handler: %s", requestIDFromContext(r.Context()), string(b))
 	}
 
 	if r.Header.Get("Content-Type") == "application/json" {
@@ -585,6 +636,75 @@ func (h *Handler) servePing(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// serveData writes the current meta data as JSON with password hashes removed.
+// Only admin users may read it when authentication is enabled.
+func (h *Handler) serveData(w http.ResponseWriter, r *http.Request, user *meta.UserInfo) {
+	if !h.DataEnabled {
+		http.NotFound(w, r)
+		return
+	}
+
+	pretty := r.FormValue("pretty") == "true"
+	if h.requireAuthentication && (user == nil || !user.Admin) {
+		httpError(w, "admin privilege required", pretty, http.StatusForbidden)
+		return
+	}
+
+	data, err := h.MetaStore.ReadConsistent()
+	if err != nil {
+		httpError(w, err.Error(), pretty, http.StatusServiceUnavailable)
+		return
+	}
+
+	// ReadConsistent returns a copy so the hashes can be cleared in place.
+	for i := range data.Users {
+		data.Users[i].Hash = ""
+	}
+
+	var b []byte
+	if pretty {
+		b, err = json.MarshalIndent(data, "", "    ")
+	} else {
+		b, err = json.Marshal(data)
+	}
+	if err != nil {
+		httpError(w, err.Error(), pretty, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("content-type", "application/json")
+	w.Write(b)
+}
+
+// serveSnapshot forces a snapshot of the meta store's raft log so the log
+// can be compacted. It is only served when enabled, and only to admin users
+// when authentication is enabled.
+func (h *Handler) serveSnapshot(w http.ResponseWriter, r *http.Request, user *meta.UserInfo) {
+	if !h.SnapshotEnabled {
+		http.NotFound(w, r)
+		return
+	}
+
+	pretty := r.FormValue("pretty") == "true"
+	if h.requireAuthentication && (user == nil || !user.Admin) {
+		httpError(w, "admin privilege required", pretty, http.StatusForbidden)
+		return
+	}
+
+	if err := h.MetaStore.ForceSnapshot(); errors.Is(err, meta.ErrNotLeader) {
+		httpError(w, err.Error(), pretty, http.StatusServiceUnavailable)
+		return
+	} else if errors.Is(err, meta.ErrSnapshotInProgress) {
+		httpError(w, err.Error(), pretty, http.StatusConflict)
+		return
+	} else if err != nil {
+		httpError(w, err.Error(), pretty, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
 // convertToEpoch converts result timestamps from time.Time to the specified epoch.
 func convertToEpoch(r *influxql.Result, epoch string) {
 	divisor := int64(1)
@@ -724,19 +844,19 @@ func authenticate(inner func(http.ResponseWriter, *http.Request, *meta.UserInfo)
 			username, password, err := parseCredentials(r)
 			if err != nil {
 				h.statMap.Add(statAuthFail, 1)
-				httpError(w, err.Error(), false, http.StatusUnauthorized)
+				authError(w, err.Error())
 				return
 			}
 			if username == "" {
 				h.statMap.Add(statAuthFail, 1)
-				httpError(w, "username required", false, http.StatusUnauthorized)
+				authError(w, "username required")
 				return
 			}
 
 			user, err = h.MetaStore.Authenticate(username, password)
 			if err != nil {
 				h.statMap.Add(statAuthFail, 1)
-				httpError(w, err.Error(), false, http.StatusUnauthorized)
+				authError(w, err.Error())
 				return
 			}
 		}
@@ -744,6 +864,12 @@ func authenticate(inner func(http.ResponseWriter, *http.Request, *meta.UserInfo)
 	})
 }
 
+// authError returns a 401 that asks the client for basic auth credentials.
+func authError(w http.ResponseWriter, error string) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="InfluxDB"`)
+	httpError(w, error, false, http.StatusUnauthorized)
+}
+
 type gzipResponseWriter struct {
 	io.Writer
 	http.ResponseWriter
@@ -772,6 +898,22 @@ func gzipFilter(inner http.Handler) http.Handler {
 	})
 }
 
+// limitBody caps the size of the request body at h.MaxBodySize.
+func limitBody(inner http.Handler, h *Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.MaxBodySize > 0 {
+			r.Body = http.MaxBytesReader(w, r.Body, int64(h.MaxBodySize))
+		}
+		inner.ServeHTTP(w, r)
+	})
+}
+
+// isMaxBytesError returns true if err was caused by a body exceeding its limit.
+func isMaxBytesError(err error) bool {
+	var e *http.MaxBytesError
+	return errors.As(err, &e)
+}
+
 // versionHeader takes a HTTP handler and returns a HTTP handler
 // and adds the X-INFLUXBD-VERSION header to outgoing responses.
 func versionHeader(inner http.Handler, h *Handler) http.Handler {
@@ -781,19 +923,33 @@ func versionHeader(inner http.Handler, h *Handler) http.Handler {
 	})
 }
 
-// cors responds to incoming requests and adds the appropriate cors headers
-// TODO: corylanou: add the ability to configure this in our config
-func cors(inner http.Handler) http.Handler {
+// defaultCORSMethods are the methods allowed for cross-origin requests when
+// none are configured.
+var defaultCORSMethods = []string{
+	`DELETE`,
+	`GET`,
+	`OPTIONS`,
+	`POST`,
+	`PUT`,
+}
+
+// cors responds to incoming requests and adds the appropriate cors headers.
+// Requests from origins not in h.AllowedOrigins get no cors headers; an
+// empty list allows every origin.
+func cors(inner http.Handler, h *Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if origin := r.Header.Get("Origin"); origin != "" {
+		if len(h.AllowedOrigins) > 0 {
+			w.Header().Add(`Vary`, `Origin`)
+		}
+
+		if origin := r.Header.Get("Origin"); origin != "" && h.originAllowed(origin) {
+			methods := h.AllowedMethods
+			if len(methods) == 0 {
+				methods = defaultCORSMethods
+			}
+
 			w.Header().Set(`Access-Control-Allow-Origin`, origin)
-			w.Header().Set(`Access-Control-Allow-Methods`, strings.Join([]string{
-				`DELETE`,
-				`GET`,
-				`OPTIONS`,
-				`POST`,
-				`PUT`,
-			}, ", "))
+			w.Header().Set(`Access-Control-Allow-Methods`, strings.Join(methods, ", "))
 
 			w.Header().Set(`Access-Control-Allow-Headers`, strings.Join([]string{
 				`Accept`,
@@ -819,27 +975,82 @@ func cors(inner http.Handler) http.Handler {
 	})
 }
 
+// originAllowed returns true if cross-origin requests from origin are allowed.
+func (h *Handler) originAllowed(origin string) bool {
+	if len(h.AllowedOrigins) == 0 {
+		return true
+	}
+	for _, o := range h.AllowedOrigins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestID tags each request with an id that is written in the access log,
+// returned in the Request-Id and X-Request-Id headers and attached to the
+// request's context. An id sent by the client in either header is kept so
+// a request can be followed across nodes; otherwise one is generated.
 func requestID(inner http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		uid := uuid.TimeUUID()
-		r.Header.Set("Request-Id", uid.String())
-		w.Header().Set("Request-Id", r.Header.Get("Request-Id"))
+		id := r.Header.Get("X-Request-Id")
+		if id == "" {
+			id = r.Header.Get("Request-Id")
+		}
+		if !validRequestID(id) {
+			id = uuid.TimeUUID().String()
+		}
+		r.Header.Set("Request-Id", id)
+		w.Header().Set("Request-Id", id)
+		w.Header().Set("X-Request-Id", id)
 
-		inner.ServeHTTP(w, r)
+		inner.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
 	})
 }
 
-func logging(inner http.Handler, name string, weblog *log.Logger) http.Handler {
+// requestIDKey is the context key of the request id.
+type requestIDKey struct{}
+
+// requestIDFromContext returns the request id attached to ctx by requestID.
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// validRequestID returns true if id can be used as a request id. Ids are
+// written in space separated log lines, so only short ids of visible ASCII
+// characters are accepted.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// logging writes an access log line for each request to h.Logger if access
+// logging is enabled. The logger and setting are read when the request
+// completes so they can be changed after the routes are set.
+func logging(inner http.Handler, name string, h *Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 		l := &responseLogger{w: w}
 		inner.ServeHTTP(l, r)
+		if enabled, _ := h.logSettings(); !enabled {
+			return
+		}
 		logLine := buildLogLine(l, r, start)
-		weblog.Println(logLine)
+		h.Logger.Println(logLine)
 	})
 }
 
-func recovery(inner http.Handler, name string, weblog *log.Logger) http.Handler {
+// recovery logs panics from inner to h.Logger instead of crashing.
+func recovery(inner http.Handler, name string, h *Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 		l := &responseLogger{w: w}
@@ -848,7 +1059,7 @@ func recovery(inner http.Handler, name string, weblog *log.Logger) http.Handler
 			if err := recover(); err != nil {
 				logLine := buildLogLine(l, r, start)
 				logLine = fmt.Sprintf(`%s [panic:%s]`, logLine, err)
-				weblog.Println(logLine)
+				h.Logger.Println(logLine)
 			}
 		}()
 
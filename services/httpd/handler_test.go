@@ -2,14 +2,17 @@ package httpd_test
 
 import (
 	"bytes"
+	"compress/gzip"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
 	"regexp"
+	"strings"
 	"testing"
 	"time"
 
@@ -304,6 +307,217 @@ func TestHandler_Query_ErrResult(t *testing.T) {
 	}
 }
 
+// Ensure the handler only serves profiles when pprof is enabled.
+func TestHandler_Pprof(t *testing.T) {
+	for i, tt := range []struct {
+		enabled bool
+		code    int
+	}{
+		{enabled: true, code: http.StatusOK},
+		{enabled: false, code: http.StatusNotFound},
+	} {
+		h := NewHandler(false)
+		h.PprofEnabled = tt.enabled
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, MustNewRequest("GET", "/debug/pprof/goroutine", nil))
+		if w.Code != tt.code {
+			t.Errorf("%d. enabled=%v: unexpected status: %d", i, tt.enabled, w.Code)
+		}
+	}
+}
+
+// Ensure the handler serves the meta data as JSON without password hashes.
+func TestHandler_Data(t *testing.T) {
+	h := NewHandler(false)
+	h.MetaStore.ReadConsistentFn = func() (*meta.Data, error) {
+		return &meta.Data{
+			Index:     10,
+			Databases: []meta.DatabaseInfo{{Name: "db0"}},
+			Users:     []meta.UserInfo{{Name: "alice", Hash: "secret", Admin: true}},
+		}, nil
+	}
+
+	// The route is hidden unless enabled.
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, MustNewRequest("GET", "/data", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("unexpected status when disabled: %d", w.Code)
+	}
+
+	h.DataEnabled = true
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, MustNewRequest("GET", "/data", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %d", w.Code)
+	} else if strings.Contains(w.Body.String(), "secret") {
+		t.Fatalf("hash not redacted: %s", w.Body.String())
+	}
+
+	var data meta.Data
+	if err := json.Unmarshal(w.Body.Bytes(), &data); err != nil {
+		t.Fatalf("invalid json: %s", err)
+	} else if data.Index != 10 || len(data.Databases) != 1 || data.Databases[0].Name != "db0" {
+		t.Fatalf("unexpected data: %+v", data)
+	} else if len(data.Users) != 1 || data.Users[0].Name != "alice" || data.Users[0].Hash != "" {
+		t.Fatalf("unexpected users: %+v", data.Users)
+	}
+}
+
+// Ensure the meta data is only served to admin users when auth is enabled.
+func TestHandler_Data_Admin(t *testing.T) {
+	for i, tt := range []struct {
+		admin bool
+		code  int
+	}{
+		{admin: true, code: http.StatusOK},
+		{admin: false, code: http.StatusForbidden},
+	} {
+		h := NewHandler(true)
+		h.DataEnabled = true
+		h.MetaStore.UsersFn = func() ([]meta.UserInfo, error) {
+			return []meta.UserInfo{{Name: "alice", Admin: tt.admin}}, nil
+		}
+		h.MetaStore.AuthenticateFn = func(username, password string) (*meta.UserInfo, error) {
+			return &meta.UserInfo{Name: "alice", Admin: tt.admin}, nil
+		}
+		h.MetaStore.ReadConsistentFn = func() (*meta.Data, error) { return &meta.Data{}, nil }
+
+		w := httptest.NewRecorder()
+		r := MustNewRequest("GET", "/data", nil)
+		r.SetBasicAuth("alice", "pass")
+		h.ServeHTTP(w, r)
+		if w.Code != tt.code {
+			t.Errorf("%d. admin=%v: unexpected status: %d", i, tt.admin, w.Code)
+		}
+	}
+}
+
+// Ensure the handler forces a snapshot and reports why one can't be taken.
+func TestHandler_Snapshot(t *testing.T) {
+	h := NewHandler(false)
+	var err error
+	h.MetaStore.ForceSnapshotFn = func() error { return err }
+
+	// The route is hidden unless enabled, even when /data is served.
+	h.DataEnabled = true
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, MustNewRequest("POST", "/data/snapshot", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("unexpected status when disabled: %d", w.Code)
+	}
+
+	h.SnapshotEnabled = true
+	for i, tt := range []struct {
+		err  error
+		code int
+	}{
+		{err: nil, code: http.StatusNoContent},
+		{err: &meta.NotLeaderError{Leader: "host1:8088"}, code: http.StatusServiceUnavailable},
+		{err: meta.ErrSnapshotInProgress, code: http.StatusConflict},
+		{err: errors.New("marker"), code: http.StatusInternalServerError},
+	} {
+		err = tt.err
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, MustNewRequest("POST", "/data/snapshot", nil))
+		if w.Code != tt.code {
+			t.Errorf("%d. unexpected status: %d", i, w.Code)
+		}
+	}
+}
+
+// Ensure the handler answers preflight requests from allowed origins.
+func TestHandler_CORS_Preflight(t *testing.T) {
+	h := NewHandler(false)
+	h.AllowedOrigins = []string{"https://admin.example.com"}
+	h.AllowedMethods = []string{"GET", "POST"}
+
+	w := httptest.NewRecorder()
+	r := MustNewRequest("OPTIONS", "/query", nil)
+	r.Header.Set("Origin", "https://admin.example.com")
+	r.Header.Set("Access-Control-Request-Method", "POST")
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %d", w.Code)
+	} else if v := w.Header().Get("Access-Control-Allow-Origin"); v != "https://admin.example.com" {
+		t.Fatalf("unexpected allow origin: %q", v)
+	} else if v := w.Header().Get("Access-Control-Allow-Methods"); v != "GET, POST" {
+		t.Fatalf("unexpected allow methods: %q", v)
+	}
+}
+
+// Ensure the handler only adds cors headers for allowed origins.
+func TestHandler_CORS_Origin(t *testing.T) {
+	for i, tt := range []struct {
+		allowed []string
+		origin  string
+		exp     string
+	}{
+		{allowed: nil, origin: "https://any.example.com", exp: "https://any.example.com"},
+		{allowed: []string{"*"}, origin: "https://any.example.com", exp: "https://any.example.com"},
+		{allowed: []string{"https://admin.example.com"}, origin: "https://admin.example.com", exp: "https://admin.example.com"},
+		{allowed: []string{"https://admin.example.com"}, origin: "https://evil.example.com", exp: ""},
+	} {
+		h := NewHandler(false)
+		h.AllowedOrigins = tt.allowed
+		w := httptest.NewRecorder()
+		r := MustNewRequest("GET", "/ping", nil)
+		r.Header.Set("Origin", tt.origin)
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusNoContent {
+			t.Errorf("%d. unexpected status: %d", i, w.Code)
+		} else if v := w.Header().Get("Access-Control-Allow-Origin"); v != tt.exp {
+			t.Errorf("%d. unexpected allow origin: %q", i, v)
+		}
+	}
+}
+
+// Ensure the handler requires basic auth credentials when auth is enabled.
+func TestHandler_Query_BasicAuth(t *testing.T) {
+	for i, tt := range []struct {
+		username, password string
+		code               int
+	}{
+		{username: "alice", password: "pass", code: http.StatusOK},
+		{username: "alice", password: "wrong", code: http.StatusUnauthorized},
+		{code: http.StatusUnauthorized},
+	} {
+		h := NewHandler(true)
+		h.MetaStore.UsersFn = func() ([]meta.UserInfo, error) {
+			return []meta.UserInfo{{Name: "alice", Admin: true}}, nil
+		}
+		h.MetaStore.AuthenticateFn = func(username, password string) (*meta.UserInfo, error) {
+			if username != "alice" || password != "pass" {
+				return nil, meta.ErrAuthenticate
+			}
+			return &meta.UserInfo{Name: "alice", Admin: true}, nil
+		}
+		h.QueryExecutor.AuthorizeFn = func(u *meta.UserInfo, q *influxql.Query, db string) error { return nil }
+		h.QueryExecutor.ExecuteQueryFn = func(q *influxql.Query, db string, chunkSize int, closing chan struct{}) (<-chan *influxql.Result, error) {
+			return NewResultChan(&influxql.Result{StatementID: 1}), nil
+		}
+
+		w := httptest.NewRecorder()
+		r := MustNewJSONRequest("GET", "/query?q=SHOW+DATABASES", nil)
+		if tt.username != "" {
+			r.SetBasicAuth(tt.username, tt.password)
+		}
+		h.ServeHTTP(w, r)
+		if w.Code != tt.code {
+			t.Errorf("%d. unexpected status: %d", i, w.Code)
+		} else if v := w.Header().Get("WWW-Authenticate"); tt.code == http.StatusUnauthorized && v != `Basic realm="InfluxDB"` {
+			t.Errorf("%d. unexpected WWW-Authenticate: %q", i, v)
+		}
+	}
+
+	// Ping stays open without credentials.
+	h := NewHandler(true)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, MustNewRequest("GET", "/ping", nil))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("unexpected ping status: %d", w.Code)
+	}
+}
+
 // Ensure the handler handles ping requests correctly.
 func TestHandler_Ping(t *testing.T) {
 	h := NewHandler(false)
@@ -318,6 +532,36 @@ func TestHandler_Ping(t *testing.T) {
 	}
 }
 
+// Ensure a request id sent by the client is echoed and written in the log.
+func TestHandler_RequestID(t *testing.T) {
+	h := NewHandler(false)
+	var buf bytes.Buffer
+	h.Logger = log.New(&buf, "", 0)
+
+	w := httptest.NewRecorder()
+	r := MustNewRequest("GET", "/ping", nil)
+	r.Header.Set("X-Request-Id", "abc-123")
+	h.ServeHTTP(w, r)
+	if id := w.Header().Get("X-Request-Id"); id != "abc-123" {
+		t.Fatalf("unexpected X-Request-Id: %q", id)
+	} else if id := w.Header().Get("Request-Id"); id != "abc-123" {
+		t.Fatalf("unexpected Request-Id: %q", id)
+	} else if !strings.Contains(buf.String(), " abc-123 ") {
+		t.Fatalf("request id not logged: %s", buf.String())
+	}
+
+	// Missing and unusable ids are replaced with a generated one.
+	for _, id := range []string{"", "bad id", strings.Repeat("x", 129)} {
+		w := httptest.NewRecorder()
+		r := MustNewRequest("GET", "/ping", nil)
+		r.Header.Set("X-Request-Id", id)
+		h.ServeHTTP(w, r)
+		if got := w.Header().Get("X-Request-Id"); got == "" || got == id {
+			t.Fatalf("%q: unexpected X-Request-Id: %q", id, got)
+		}
+	}
+}
+
 // Ensure the handler handles ping requests correctly, when waiting for leader.
 func TestHandler_PingWaitForLeader(t *testing.T) {
 	h := NewHandler(false)
@@ -374,6 +618,34 @@ func TestHandler_HandleBadRequestBody(t *testing.T) {
 	}
 }
 
+// Ensure bodies larger than the limit are rejected, with or without gzip.
+func TestHandler_Write_MaxBodySize(t *testing.T) {
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(bytes.Repeat([]byte("x"), 1024))
+	zw.Close()
+
+	for i, tt := range []struct {
+		body     []byte
+		encoding string
+	}{
+		{body: make([]byte, 1024)},
+		{body: gz.Bytes(), encoding: "gzip"},
+	} {
+		h := NewHandler(false)
+		h.MaxBodySize = 100
+		w := httptest.NewRecorder()
+		r := MustNewRequest("POST", "/write?db=foo", bytes.NewReader(tt.body))
+		if tt.encoding != "" {
+			r.Header.Set("Content-Encoding", tt.encoding)
+		}
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("%d. unexpected status: %d", i, w.Code)
+		}
+	}
+}
+
 func TestMarshalJSON_NoPretty(t *testing.T) {
 	if b := httpd.MarshalJSON(struct {
 		Name string `json:"name"`
@@ -487,10 +759,12 @@ func NewHandler(requireAuthentication bool) *Handler {
 
 // HandlerMetaStore is a mock implementation of Handler.MetaStore.
 type HandlerMetaStore struct {
-	WaitForLeaderFn func(d time.Duration) error
-	DatabaseFn      func(name string) (*meta.DatabaseInfo, error)
-	AuthenticateFn  func(username, password string) (ui *meta.UserInfo, err error)
-	UsersFn         func() ([]meta.UserInfo, error)
+	WaitForLeaderFn  func(d time.Duration) error
+	DatabaseFn       func(name string) (*meta.DatabaseInfo, error)
+	AuthenticateFn   func(username, password string) (ui *meta.UserInfo, err error)
+	UsersFn          func() ([]meta.UserInfo, error)
+	ReadConsistentFn func() (*meta.Data, error)
+	ForceSnapshotFn  func() error
 }
 
 func (s *HandlerMetaStore) WaitForLeader(d time.Duration) error {
@@ -513,6 +787,14 @@ func (s *HandlerMetaStore) Users() ([]meta.UserInfo, error) {
 	return s.UsersFn()
 }
 
+func (s *HandlerMetaStore) ReadConsistent() (*meta.Data, error) {
+	return s.ReadConsistentFn()
+}
+
+func (s *HandlerMetaStore) ForceSnapshot() error {
+	return s.ForceSnapshotFn()
+}
+
 // HandlerQueryExecutor is a mock implementation of Handler.QueryExecutor.
 type HandlerQueryExecutor struct {
 	AuthorizeFn    func(u *meta.UserInfo, q *influxql.Query, db string) error
@@ -99,7 +99,7 @@ func buildLogLine(l *responseLogger, r *http.Request, start time.Time) string {
 
 	fields := []string{
 		host,
-		"-",
+		detect(clientIdentity(r), "-"),
 		detect(username, "-"),
 		fmt.Sprintf("[%s]", start.Format("02/Jan/2006:15:04:05 -0700")),
 		r.Method,
@@ -126,6 +126,14 @@ func detect(values ...string) string {
 	return ""
 }
 
+// clientIdentity returns the common name of a verified client certificate.
+func clientIdentity(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	return r.TLS.VerifiedChains[0][0].Subject.CommonName
+}
+
 // parses the username either from the url or auth header
 func parseUsername(r *http.Request) string {
 	var (
@@ -1,7 +1,9 @@
 package httpd
 
 import (
+	"context"
 	"crypto/tls"
+	"errors"
 	"expvar"
 	"fmt"
 	"log"
@@ -9,6 +11,8 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/influxdb/influxdb"
 )
@@ -27,14 +31,42 @@ const (
 	statAuthFail                     = "authFail"          // Number of authentication failures
 )
 
+// unixPrefix is the bind address prefix for Unix sockets.
+const unixPrefix = "unix://"
+
 // Service manages the listener and handler for an HTTP endpoint.
 type Service struct {
+	mu    sync.RWMutex // protects ln
 	ln    net.Listener
 	addr  string
+	net   string
 	https bool
 	cert  string
+	key   string
 	err   chan error
 
+	// certificate is served to new TLS connections and replaced by ReloadTLS.
+	certMu      sync.RWMutex
+	certificate *tls.Certificate
+
+	tlsMinVersion     string
+	tlsCiphers        []string
+	clientCA          string
+	requireClientCert bool
+
+	server          *http.Server
+	shutdownTimeout time.Duration
+	readTimeout     time.Duration
+	writeTimeout    time.Duration
+	idleTimeout     time.Duration
+
+	// maxConnections limits the number of open connections. Zero is unlimited.
+	maxConnections int
+
+	// config is the configuration last applied by NewService or Reload.
+	configMu sync.Mutex
+	config   Config
+
 	Handler *Handler
 
 	Logger  *log.Logger
@@ -51,9 +83,23 @@ func NewService(c Config) *Service {
 
 	s := &Service{
 		addr:  c.BindAddress,
+		net:   c.BindNetwork,
 		https: c.HTTPSEnabled,
 		cert:  c.HTTPSCertificate,
+		key:   c.HTTPSPrivateKey,
 		err:   make(chan error),
+
+		tlsMinVersion:     c.TLSMinVersion,
+		tlsCiphers:        c.TLSCiphers,
+		clientCA:          c.HTTPSClientCA,
+		requireClientCert: c.HTTPSRequireClientCert,
+
+		shutdownTimeout: time.Duration(c.ShutdownTimeout),
+		readTimeout:     time.Duration(c.ReadTimeout),
+		writeTimeout:    time.Duration(c.WriteTimeout),
+		idleTimeout:     time.Duration(c.IdleTimeout),
+		maxConnections:  c.MaxConnections,
+		config:          c,
 		Handler: NewHandler(
 			c.AuthEnabled,
 			c.LogEnabled,
@@ -63,9 +109,36 @@ func NewService(c Config) *Service {
 		Logger: log.New(os.Stderr, "[httpd] ", log.LstdFlags),
 	}
 	s.Handler.Logger = s.Logger
+	s.Handler.PprofEnabled = c.PprofEnabled
+	s.Handler.DataEnabled = c.DataEnabled
+	s.Handler.SnapshotEnabled = c.SnapshotEnabled
+	s.Handler.MaxBodySize = c.MaxBodySize
+	s.Handler.AllowedOrigins = c.CORSAllowedOrigins
+	s.Handler.AllowedMethods = c.CORSAllowedMethods
 	return s
 }
 
+// Reload applies the settings in c that can change while the service is
+// running: request logging and write tracing. It returns an error and
+// changes nothing if c is invalid or changes any other setting, such as the
+// bind address or the timeouts, which need a restart.
+func (s *Service) Reload(c Config) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+	if err := checkReload(s.config, c); err != nil {
+		return err
+	}
+	s.config = c
+	s.Handler.SetLogging(c.LogEnabled, c.WriteTracing)
+
+	s.Logger.Printf("Reloaded config: log-enabled=%v write-tracing=%v", c.LogEnabled, c.WriteTracing)
+	return nil
+}
+
 // Open starts the service
 func (s *Service) Open() error {
 	s.Logger.Println("Starting HTTP service")
@@ -73,39 +146,177 @@ func (s *Service) Open() error {
 
 	// Open listener.
 	if s.https {
-		cert, err := tls.LoadX509KeyPair(s.cert, s.cert)
+		cert, err := loadCertificate(s.cert, s.key)
+		if err != nil {
+			return err
+		}
+
+		minVersion, err := tlsVersion(s.tlsMinVersion)
 		if err != nil {
 			return err
 		}
 
-		listener, err := tls.Listen("tcp", s.addr, &tls.Config{
-			Certificates: []tls.Certificate{cert},
-		})
+		ciphers, err := tlsCipherSuites(s.tlsCiphers)
 		if err != nil {
 			return err
 		}
 
+		s.setCertificate(&cert)
+
+		config := &tls.Config{
+			GetCertificate: s.getCertificate,
+			MinVersion:     minVersion,
+			CipherSuites:   ciphers,
+		}
+
+		// Verify client certificates against the client CA, if set.
+		if s.clientCA != "" {
+			pool, err := loadCertPool(s.clientCA)
+			if err != nil {
+				return err
+			}
+			config.ClientCAs = pool
+			config.ClientAuth = tls.VerifyClientCertIfGiven
+			if s.requireClientCert {
+				config.ClientAuth = tls.RequireAndVerifyClientCert
+			}
+		} else if s.requireClientCert {
+			return errors.New("client certificates required but no client CA set")
+		}
+
+		ln, err := s.listen()
+		if err != nil {
+			return err
+		}
+		listener := tls.NewListener(ln, config)
+
 		s.Logger.Println("Listening on HTTPS:", listener.Addr().String())
-		s.ln = listener
+		s.setListener(listener)
 	} else {
-		listener, err := net.Listen("tcp", s.addr)
+		listener, err := s.listen()
 		if err != nil {
 			return err
 		}
 
 		s.Logger.Println("Listening on HTTP:", listener.Addr().String())
-		s.ln = listener
+		s.setListener(listener)
 	}
 
 	// Begin listening for requests in a separate goroutine.
-	go s.serve()
+	s.server = &http.Server{
+		Handler:      s.Handler,
+		ReadTimeout:  s.readTimeout,
+		WriteTimeout: s.writeTimeout,
+		IdleTimeout:  s.idleTimeout,
+	}
+	go s.serve(s.ln)
+	return nil
+}
+
+// ReloadTLS reads the certificate and private key files again and serves the
+// new certificate to connections accepted from then on. Open connections and
+// the listener are left as they are. If the files cannot be loaded then the
+// current certificate is kept. Does nothing if HTTPS is not enabled.
+func (s *Service) ReloadTLS() error {
+	if !s.https {
+		return nil
+	}
+
+	cert, err := loadCertificate(s.cert, s.key)
+	if err != nil {
+		return err
+	}
+	s.setCertificate(&cert)
+	s.Logger.Println("Reloaded HTTPS certificate:", s.cert)
 	return nil
 }
 
-// Close closes the underlying listener.
+func (s *Service) setCertificate(cert *tls.Certificate) {
+	s.certMu.Lock()
+	s.certificate = cert
+	s.certMu.Unlock()
+}
+
+// getCertificate returns the current certificate for a TLS handshake.
+func (s *Service) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.certMu.RLock()
+	defer s.certMu.RUnlock()
+	return s.certificate, nil
+}
+
+// setListener sets the listener that Addr reports.
+func (s *Service) setListener(ln net.Listener) {
+	s.mu.Lock()
+	s.ln = ln
+	s.mu.Unlock()
+}
+
+// listen opens a listener on the bind address and applies the connection limit.
+func (s *Service) listen() (net.Listener, error) {
+	ln, err := s.listenAddr()
+	if err != nil {
+		return nil, err
+	}
+	if s.maxConnections > 0 {
+		ln = newLimitListener(ln, s.maxConnections)
+	}
+	return ln, nil
+}
+
+// listenAddr opens a listener on the bind address. Addresses prefixed with
+// "unix://" are bound to a Unix socket at the given path.
+func (s *Service) listenAddr() (net.Listener, error) {
+	if !strings.HasPrefix(s.addr, unixPrefix) {
+		network := s.net
+		if network == "" {
+			network = DefaultBindNetwork
+		}
+		return net.Listen(network, s.addr)
+	}
+
+	path := strings.TrimPrefix(s.addr, unixPrefix)
+
+	// Remove a stale socket left behind by an unclean shutdown, unless
+	// another process is still serving it. Other files are never removed.
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("not a unix socket: %s", path)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("unix socket in use: %s", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	// The socket file is removed when the listener is closed.
+	return net.Listen("unix", path)
+}
+
+// Close closes the underlying listener and waits for in-flight requests to
+// complete. Remaining connections are closed once the shutdown timeout expires.
 func (s *Service) Close() error {
-	if s.ln != nil {
-		return s.ln.Close()
+	s.mu.Lock()
+	ln := s.ln
+	s.ln = nil
+	s.mu.Unlock()
+
+	if s.server == nil {
+		if ln != nil {
+			return ln.Close()
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout)
+	defer cancel()
+	if err := s.server.Shutdown(ctx); err == context.DeadlineExceeded {
+		s.Logger.Println("Timed out waiting for HTTP requests to complete")
+		return s.server.Close()
+	} else if err != nil {
+		return err
 	}
 	return nil
 }
@@ -118,20 +329,52 @@ func (s *Service) SetLogger(l *log.Logger) {
 // Err returns a channel for fatal errors that occur on the listener.
 func (s *Service) Err() <-chan error { return s.err }
 
-// Addr returns the listener's address. Returns nil if listener is closed.
+// Addr returns the listener's address. After Open returns it holds the
+// bound port, even if the bind address used port 0. Returns nil before Open
+// and after Close.
 func (s *Service) Addr() net.Addr {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	if s.ln != nil {
 		return s.ln.Addr()
 	}
 	return nil
 }
 
+// Port returns the bound TCP port. Returns 0 if the service is not open or
+// is listening on a Unix socket.
+func (s *Service) Port() int {
+	if addr, ok := s.Addr().(*net.TCPAddr); ok {
+		return addr.Port
+	}
+	return 0
+}
+
+// URL returns the base URL of the service, such as "http://127.0.0.1:8086",
+// or "unix:///var/run/influxdb.sock" when listening on a Unix socket, in the
+// form accepted by the bind-address setting. Returns an empty string if the
+// service is not open.
+func (s *Service) URL() string {
+	switch addr := s.Addr().(type) {
+	case *net.TCPAddr:
+		scheme := "http"
+		if s.https {
+			scheme = "https"
+		}
+		return scheme + "://" + addr.String()
+	case *net.UnixAddr:
+		return unixPrefix + addr.Name
+	default:
+		return ""
+	}
+}
+
 // serve serves the handler from the listener.
-func (s *Service) serve() {
+func (s *Service) serve(ln net.Listener) {
 	// The listener was closed so exit
 	// See https://github.com/golang/go/issues/4373
-	err := http.Serve(s.ln, s.Handler)
-	if err != nil && !strings.Contains(err.Error(), "closed") {
-		s.err <- fmt.Errorf("listener failed: addr=%s, err=%s", s.Addr(), err)
+	err := s.server.Serve(ln)
+	if err != nil && err != http.ErrServerClosed && !strings.Contains(err.Error(), "closed") {
+		s.err <- fmt.Errorf("listener failed: addr=%s, err=%s", ln.Addr(), err)
 	}
 }
@@ -0,0 +1,675 @@
+package httpd_test
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/influxdb/influxdb/services/httpd"
+	"github.com/influxdb/influxdb/toml"
+)
+
+// Ensure in-flight requests complete when the service is closed.
+func TestService_Close_DrainsRequests(t *testing.T) {
+	c := httpd.NewConfig()
+	c.BindAddress = "127.0.0.1:0"
+	c.ShutdownTimeout = toml.Duration(5 * time.Second)
+	s := httpd.NewService(c)
+
+	// Block ping requests until the service is closing.
+	started := make(chan struct{})
+	s.Handler.MetaStore = &HandlerMetaStore{
+		WaitForLeaderFn: func(d time.Duration) error {
+			close(started)
+			time.Sleep(200 * time.Millisecond)
+			return nil
+		},
+	}
+	if err := s.Open(); err != nil {
+		t.Fatal(err)
+	}
+
+	errCh := make(chan error)
+	go func() {
+		resp, err := http.Get(fmt.Sprintf("http://%s/ping?wait_for_leader=1s", s.Addr()))
+		if err != nil {
+			errCh <- err
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNoContent {
+			errCh <- fmt.Errorf("unexpected status: %d", resp.StatusCode)
+			return
+		}
+		errCh <- nil
+	}()
+
+	<-started
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure closing the service more than once is a no-op.
+func TestService_Close_Twice(t *testing.T) {
+	c := httpd.NewConfig()
+	c.BindAddress = "127.0.0.1:0"
+	s := httpd.NewService(c)
+	s.Handler.MetaStore = &HandlerMetaStore{}
+	if err := s.Open(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := s.Close(); err != nil {
+			t.Fatalf("%d. unexpected error: %s", i, err)
+		}
+	}
+}
+
+// Ensure a client that stalls while sending a request body is timed out.
+func TestService_ReadTimeout(t *testing.T) {
+	c := httpd.NewConfig()
+	c.BindAddress = "127.0.0.1:0"
+	c.ReadTimeout = toml.Duration(100 * time.Millisecond)
+	s := httpd.NewService(c)
+	s.Handler.MetaStore = &HandlerMetaStore{}
+	if err := s.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Send only part of the declared body and then stall.
+	if _, err := fmt.Fprint(conn, "POST /write?db=db0 HTTP/1.1\r\nHost: localhost\r\nContent-Length: 100\r\n\r\ncpu"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The server should give up on the body and close the connection long
+	// before the client's deadline.
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	b, err := ioutil.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("connection not closed by server: %s", err)
+	} else if !bytes.HasPrefix(b, []byte("HTTP/1.1 400 ")) {
+		t.Fatalf("unexpected response: %q", b)
+	}
+}
+
+// Ensure connections beyond the limit are not served until one closes.
+func TestService_MaxConnections(t *testing.T) {
+	c := httpd.NewConfig()
+	c.BindAddress = "127.0.0.1:0"
+	c.MaxConnections = 2
+	s := httpd.NewService(c)
+	s.Handler.MetaStore = &HandlerMetaStore{}
+	if err := s.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ping := func(conn net.Conn, timeout time.Duration) error {
+		if _, err := fmt.Fprint(conn, "GET /ping HTTP/1.1\r\nHost: localhost\r\n\r\n"); err != nil {
+			return err
+		}
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		b := make([]byte, 12)
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return err
+		} else if string(b) != "HTTP/1.1 204" {
+			return fmt.Errorf("unexpected response: %q", b)
+		}
+		return nil
+	}
+
+	// Fill the limit with kept-alive connections.
+	var conns []net.Conn
+	for i := 0; i < c.MaxConnections; i++ {
+		conn, err := net.Dial("tcp", s.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		if err := ping(conn, 5*time.Second); err != nil {
+			t.Fatalf("conn %d: %s", i, err)
+		}
+		conns = append(conns, conn)
+	}
+
+	// The extra connection is not served while the limit is reached.
+	extra, err := net.Dial("tcp", s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer extra.Close()
+	if err := ping(extra, 200*time.Millisecond); err == nil {
+		t.Fatal("expected extra connection to wait")
+	} else if e, ok := err.(net.Error); !ok || !e.Timeout() {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// Closing a connection frees a slot for the waiting one.
+	conns[0].Close()
+	extra.SetReadDeadline(time.Now().Add(5 * time.Second))
+	b := make([]byte, 12)
+	if _, err := io.ReadFull(extra, b); err != nil {
+		t.Fatal(err)
+	} else if string(b) != "HTTP/1.1 204" {
+		t.Fatalf("unexpected response: %q", b)
+	}
+}
+
+// Ensure the service binds to the configured network.
+func TestService_Open_BindNetwork(t *testing.T) {
+	c := httpd.NewConfig()
+	c.BindAddress = ":0"
+	c.BindNetwork = "tcp4"
+	s := httpd.NewService(c)
+	s.Handler.MetaStore = &HandlerMetaStore{}
+	if err := s.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if addr, ok := s.Addr().(*net.TCPAddr); !ok {
+		t.Fatalf("unexpected addr type: %T", s.Addr())
+	} else if addr.IP.To4() == nil {
+		t.Fatalf("expected an IPv4 address: %s", addr)
+	}
+}
+
+// Ensure the service reports the port it bound when given port 0.
+func TestService_Open_EphemeralPort(t *testing.T) {
+	c := httpd.NewConfig()
+	c.BindAddress = "127.0.0.1:0"
+	s := httpd.NewService(c)
+	s.Handler.MetaStore = &HandlerMetaStore{}
+	if err := s.Open(); err != nil {
+		t.Fatal(err)
+	}
+
+	port := s.Port()
+	if port == 0 {
+		t.Fatal("expected a bound port")
+	} else if exp := fmt.Sprintf("http://127.0.0.1:%d", port); s.URL() != exp {
+		t.Fatalf("unexpected url: got %s, exp %s", s.URL(), exp)
+	}
+
+	resp, err := http.Get(s.URL() + "/ping")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("unexpected status: %d", resp.StatusCode)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	} else if s.Addr() != nil {
+		t.Fatalf("unexpected addr after close: %s", s.Addr())
+	} else if s.Port() != 0 {
+		t.Fatalf("unexpected port after close: %d", s.Port())
+	}
+}
+
+// Ensure the service serves HTTPS with a separate certificate and key file.
+func TestService_Open_HTTPSPrivateKey(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	c := httpd.NewConfig()
+	c.BindAddress = "127.0.0.1:0"
+	c.HTTPSEnabled = true
+	c.HTTPSCertificate, c.HTTPSPrivateKey = MustWriteCertificate(dir, false)
+	s := httpd.NewService(c)
+	s.Handler.MetaStore = &HandlerMetaStore{}
+	if err := s.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}}
+	resp, err := client.Get(fmt.Sprintf("https://%s/ping", s.Addr()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("unexpected status: %d", resp.StatusCode)
+	}
+}
+
+// Ensure a reloaded certificate is served to new connections without
+// restarting the listener.
+func TestService_ReloadTLS(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	c := httpd.NewConfig()
+	c.BindAddress = "127.0.0.1:0"
+	c.HTTPSEnabled = true
+	c.HTTPSCertificate, c.HTTPSPrivateKey = MustWriteCertificate(dir, false)
+	s := httpd.NewService(c)
+	s.Handler.MetaStore = &HandlerMetaStore{}
+	if err := s.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	addr := s.Addr().String()
+
+	// peerCert returns the certificate presented to a new connection.
+	peerCert := func() []byte {
+		conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].Raw
+	}
+	old := peerCert()
+
+	// A connection opened before the reload stays usable.
+	conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Replace the files with a new certificate and reload.
+	MustWriteCertificate(dir, false)
+	if err := s.ReloadTLS(); err != nil {
+		t.Fatal(err)
+	}
+
+	if cert := peerCert(); bytes.Equal(cert, old) {
+		t.Fatal("expected new certificate after reload")
+	} else if s.Addr().String() != addr {
+		t.Fatalf("unexpected addr: %s", s.Addr())
+	}
+
+	if _, err := conn.Write([]byte("GET /ping HTTP/1.0\r\n\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("unexpected status: %d", resp.StatusCode)
+	}
+
+	// A failed reload keeps serving the current certificate.
+	current := peerCert()
+	if err := os.Remove(c.HTTPSPrivateKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ReloadTLS(); err == nil {
+		t.Fatal("expected error")
+	}
+	if cert := peerCert(); !bytes.Equal(cert, current) {
+		t.Fatal("unexpected certificate after failed reload")
+	}
+}
+
+// Ensure Reload toggles request logging and refuses settings that need a restart.
+func TestService_Reload(t *testing.T) {
+	c := httpd.NewConfig()
+	c.BindAddress = "127.0.0.1:0"
+	s := httpd.NewService(c)
+	s.Handler.MetaStore = &HandlerMetaStore{}
+	var buf bytes.Buffer
+	s.Handler.Logger = log.New(&buf, "", 0)
+
+	ping := func() {
+		w := httptest.NewRecorder()
+		r, err := http.NewRequest("GET", "/ping", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.Handler.ServeHTTP(w, r)
+		if w.Code != http.StatusNoContent {
+			t.Fatalf("unexpected status: %d", w.Code)
+		}
+	}
+
+	ping()
+	if !strings.Contains(buf.String(), "GET /ping") {
+		t.Fatalf("request not logged: %s", buf.String())
+	}
+
+	// Disabling logging takes effect on the next request.
+	next := c
+	next.LogEnabled = false
+	if err := s.Reload(next); err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	ping()
+	if buf.Len() != 0 {
+		t.Fatalf("unexpected log after reload: %s", buf.String())
+	}
+
+	// Settings read by the running server are refused.
+	bind := next
+	bind.BindAddress = "127.0.0.1:1"
+	if err := s.Reload(bind); err == nil || err.Error() != "HTTP.BindAddress cannot be changed without a restart" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	timeout := next
+	timeout.WriteTimeout = toml.Duration(time.Minute)
+	if err := s.Reload(timeout); err == nil || err.Error() != "HTTP.WriteTimeout cannot be changed without a restart" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// A refused reload leaves the previous settings in place.
+	buf.Reset()
+	ping()
+	if buf.Len() != 0 {
+		t.Fatalf("unexpected log after refused reload: %s", buf.String())
+	}
+}
+
+// Ensure clients below the minimum TLS version are refused.
+func TestService_Open_TLSMinVersion(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	c := httpd.NewConfig()
+	c.BindAddress = "127.0.0.1:0"
+	c.HTTPSEnabled = true
+	c.HTTPSCertificate, c.HTTPSPrivateKey = MustWriteCertificate(dir, false)
+	c.TLSMinVersion = "1.2"
+	s := httpd.NewService(c)
+	s.Handler.MetaStore = &HandlerMetaStore{}
+	if err := s.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// A TLS 1.0 client must fail the handshake.
+	conn, err := tls.Dial("tcp", s.Addr().String(), &tls.Config{
+		InsecureSkipVerify: true,
+		MinVersion:         tls.VersionTLS10,
+		MaxVersion:         tls.VersionTLS10,
+	})
+	if err == nil {
+		conn.Close()
+		t.Fatal("expected handshake error for tls 1.0 client")
+	}
+
+	// A TLS 1.2 client is accepted.
+	conn, err = tls.Dial("tcp", s.Addr().String(), &tls.Config{
+		InsecureSkipVerify: true,
+		MaxVersion:         tls.VersionTLS12,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
+
+// Ensure client certificates are required and verified when enabled.
+func TestService_Open_RequireClientCert(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	ca := MustNewCertificate("ca", nil)
+	caPath := filepath.Join(dir, "ca.pem")
+	if err := ioutil.WriteFile(caPath, ca.CertPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c := httpd.NewConfig()
+	c.BindAddress = "127.0.0.1:0"
+	c.HTTPSEnabled = true
+	c.HTTPSCertificate, c.HTTPSPrivateKey = MustWriteCertificate(dir, false)
+	c.HTTPSClientCA = caPath
+	c.HTTPSRequireClientCert = true
+	s := httpd.NewService(c)
+	s.Handler.MetaStore = &HandlerMetaStore{}
+	if err := s.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	get := func(certs ...tls.Certificate) error {
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: true,
+				Certificates:       certs,
+			},
+		}}
+		resp, err := client.Get(fmt.Sprintf("https://%s/ping", s.Addr()))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNoContent {
+			return fmt.Errorf("unexpected status: %d", resp.StatusCode)
+		}
+		return nil
+	}
+
+	// A certificate signed by the client CA is accepted.
+	if err := get(MustNewCertificate("client", ca).TLSCertificate()); err != nil {
+		t.Fatalf("trusted client: %s", err)
+	}
+
+	// A certificate signed by another CA is refused.
+	other := MustNewCertificate("other", nil)
+	if err := get(MustNewCertificate("client", other).TLSCertificate()); err == nil {
+		t.Fatal("untrusted client: expected error")
+	}
+
+	// A client without a certificate is refused.
+	if err := get(); err == nil {
+		t.Fatal("no certificate: expected error")
+	}
+}
+
+// Ensure the service can serve requests over a Unix socket.
+func TestService_Open_UnixSocket(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "influxdb.sock")
+
+	// Leave a stale socket behind, as an unclean shutdown would.
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.SetUnlinkOnClose(false)
+	ln.Close()
+
+	c := httpd.NewConfig()
+	c.BindAddress = "unix://" + path
+	s := httpd.NewService(c)
+	s.Handler.MetaStore = &HandlerMetaStore{}
+	if err := s.Open(); err != nil {
+		t.Fatal(err)
+	}
+
+	if addr := s.Addr().String(); addr != path {
+		t.Fatalf("unexpected addr: %s", addr)
+	} else if u := s.URL(); u != "unix://"+path {
+		t.Fatalf("unexpected url: %s", u)
+	} else if s.Port() != 0 {
+		t.Fatalf("unexpected port: %d", s.Port())
+	}
+
+	// A second service cannot take over the socket while it is in use.
+	if err := httpd.NewService(c).Open(); err == nil {
+		t.Fatal("expected error opening socket in use")
+	}
+
+	client := &http.Client{Transport: &http.Transport{
+		Dial: func(network, addr string) (net.Conn, error) {
+			return net.Dial("unix", path)
+		},
+	}}
+	resp, err := client.Get("http://influxdb/ping")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("unexpected status: %d", resp.StatusCode)
+	}
+
+	// The socket file is removed on close.
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected socket to be removed: %v", err)
+	}
+}
+
+// Ensure a file at the socket path that isn't a socket is left alone.
+func TestService_Open_UnixSocket_NotSocket(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "influxdb.sock")
+
+	if err := ioutil.WriteFile(path, []byte("data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c := httpd.NewConfig()
+	c.BindAddress = "unix://" + path
+	s := httpd.NewService(c)
+	s.Handler.MetaStore = &HandlerMetaStore{}
+	if err := s.Open(); err == nil || !strings.Contains(err.Error(), "not a unix socket") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if b, err := ioutil.ReadFile(path); err != nil || string(b) != "data" {
+		t.Fatalf("file changed: %q, %v", b, err)
+	}
+}
+
+// MustTempDir returns the path to a new temporary directory.
+func MustTempDir() string {
+	dir, err := ioutil.TempDir("", "influxdb-httpd-")
+	if err != nil {
+		panic(err)
+	}
+	return dir
+}
+
+// MustWriteCertificate writes a self-signed certificate for 127.0.0.1 to dir.
+// If combined is true then the key is written to the certificate file and
+// the returned key path is empty.
+func MustWriteCertificate(dir string, combined bool) (certPath, keyPath string) {
+	cert := MustNewCertificate("127.0.0.1", nil)
+
+	certPath = filepath.Join(dir, "cert.pem")
+	if combined {
+		if err := ioutil.WriteFile(certPath, append(cert.CertPEM, cert.KeyPEM...), 0600); err != nil {
+			panic(err)
+		}
+		return certPath, ""
+	}
+
+	keyPath = filepath.Join(dir, "key.pem")
+	if err := ioutil.WriteFile(certPath, cert.CertPEM, 0600); err != nil {
+		panic(err)
+	} else if err := ioutil.WriteFile(keyPath, cert.KeyPEM, 0600); err != nil {
+		panic(err)
+	}
+	return certPath, keyPath
+}
+
+// Certificate is a PEM encoded test certificate and private key.
+type Certificate struct {
+	CertPEM []byte
+	KeyPEM  []byte
+
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// TLSCertificate returns the certificate for use in a tls.Config.
+func (c *Certificate) TLSCertificate() tls.Certificate {
+	cert, err := tls.X509KeyPair(c.CertPEM, c.KeyPEM)
+	if err != nil {
+		panic(err)
+	}
+	return cert
+}
+
+// MustNewCertificate returns a certificate for name signed by parent.
+// If parent is nil then a self-signed CA certificate is returned.
+func MustNewCertificate(name string, parent *Certificate) *Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+
+		BasicConstraintsValid: true,
+		IsCA:                  parent == nil,
+	}
+
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		panic(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		panic(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		panic(err)
+	}
+
+	return &Certificate{
+		CertPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		KeyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		cert:    cert,
+		key:     key,
+	}
+}
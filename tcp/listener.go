@@ -0,0 +1,40 @@
+package tcp
+
+import (
+	"net"
+	"time"
+)
+
+// KeepAliveListener sets the TCP keep-alive period of each accepted connection.
+type KeepAliveListener struct {
+	net.Listener
+
+	// Period between keep-alive probes. Zero disables keep-alive.
+	Period time.Duration
+}
+
+// NewKeepAliveListener returns a listener that applies period to the
+// connections accepted from ln.
+func NewKeepAliveListener(ln net.Listener, period time.Duration) *KeepAliveListener {
+	return &KeepAliveListener{Listener: ln, Period: period}
+}
+
+// Accept waits for the next connection and sets its keep-alive period.
+// Connections other than TCP are returned unchanged. Keep-alive is best
+// effort: a connection whose socket options can't be set is still returned
+// so that callers such as Mux.Serve keep accepting.
+func (ln *KeepAliveListener) Accept() (net.Conn, error) {
+	conn, err := ln.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	if tc, ok := conn.(*net.TCPConn); ok {
+		if ln.Period <= 0 {
+			tc.SetKeepAlive(false)
+		} else if err := tc.SetKeepAlive(true); err == nil {
+			tc.SetKeepAlivePeriod(ln.Period)
+		}
+	}
+	return conn, nil
+}
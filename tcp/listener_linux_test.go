@@ -0,0 +1,71 @@
+package tcp_test
+
+import (
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/influxdb/influxdb/tcp"
+)
+
+// Ensure accepted connections get the keep-alive period of the listener.
+func TestKeepAliveListener(t *testing.T) {
+	for i, tt := range []struct {
+		period  time.Duration
+		enabled bool
+		idle    int // seconds
+	}{
+		{period: 0, enabled: false},
+		{period: 7 * time.Second, enabled: true, idle: 7},
+	} {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		kln := tcp.NewKeepAliveListener(ln, tt.period)
+
+		client, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, err := kln.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		enabled, idle := keepAlive(t, conn.(*net.TCPConn))
+		if enabled != tt.enabled {
+			t.Errorf("%d. unexpected keep-alive: %v", i, enabled)
+		} else if tt.enabled && idle != tt.idle {
+			t.Errorf("%d. unexpected keep-alive idle time: %ds", i, idle)
+		}
+
+		conn.Close()
+		client.Close()
+		kln.Close()
+	}
+}
+
+// keepAlive returns whether keep-alive is enabled on conn and the idle time
+// in seconds before the first probe.
+func keepAlive(t *testing.T, conn *net.TCPConn) (enabled bool, idle int) {
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var on int
+	var serr error
+	if err := rc.Control(func(fd uintptr) {
+		if on, serr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_KEEPALIVE); serr != nil {
+			return
+		}
+		idle, serr = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE)
+	}); err != nil {
+		t.Fatal(err)
+	} else if serr != nil {
+		t.Fatal(serr)
+	}
+	return on != 0, idle
+}
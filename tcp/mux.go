@@ -99,7 +99,13 @@ func (mux *Mux) handleConn(conn net.Conn) {
 	}
 
 	// Send connection to handler.  The handler is responsible for closing the connection.
-	handler.c <- conn
+	// If the handler has been closed then nothing will accept it so drop it.
+	select {
+	case handler.c <- conn:
+	case <-handler.done:
+		conn.Close()
+		mux.Logger.Printf("tcp.Mux: handler closed: %d", typ[0])
+	}
 }
 
 // Listen returns a listener identified by header.
@@ -112,7 +118,8 @@ func (mux *Mux) Listen(header byte) net.Listener {
 
 	// Create a new listener and assign it.
 	ln := &listener{
-		c: make(chan net.Conn),
+		c:    make(chan net.Conn),
+		done: make(chan struct{}),
 	}
 	mux.m[header] = ln
 
@@ -121,20 +128,31 @@ func (mux *Mux) Listen(header byte) net.Listener {
 
 // listener is a receiver for connections received by Mux.
 type listener struct {
-	c chan net.Conn
+	c    chan net.Conn
+	done chan struct{}
+	once sync.Once
 }
 
 // Accept waits for and returns the next connection to the listener.
 func (ln *listener) Accept() (c net.Conn, err error) {
-	conn, ok := <-ln.c
-	if !ok {
+	select {
+	case conn, ok := <-ln.c:
+		if !ok {
+			return nil, errors.New("network connection closed")
+		}
+		return conn, nil
+	case <-ln.done:
 		return nil, errors.New("network connection closed")
 	}
-	return conn, nil
 }
 
-// Close is a no-op. The mux's listener should be closed instead.
-func (ln *listener) Close() error { return nil }
+// Close stops the listener. Pending and future calls to Accept return an
+// error and connections the mux receives for it afterwards are closed.
+// The mux's listener should still be closed to stop the mux.
+func (ln *listener) Close() error {
+	ln.once.Do(func() { close(ln.done) })
+	return nil
+}
 
 // Addr always returns nil.
 func (ln *listener) Addr() net.Addr { return nil }
@@ -135,3 +135,44 @@ func TestMux_Listen_ErrAlreadyRegistered(t *testing.T) {
 	mux.Listen(5)
 	mux.Listen(5)
 }
+
+// Ensure connections to a closed listener are dropped instead of blocking the mux.
+func TestMux_Listener_Close(t *testing.T) {
+	tcpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := tcp.NewMux()
+	mux.Logger = log.New(ioutil.Discard, "", 0)
+	ln := mux.Listen(5)
+
+	done := make(chan error)
+	go func() { done <- mux.Serve(tcpListener) }()
+
+	// Closing the listener should return an error from Accept.
+	if err := ln.Close(); err != nil {
+		t.Fatal(err)
+	} else if _, err := ln.Accept(); err == nil {
+		t.Fatal("expected accept error")
+	}
+
+	// A connection for the closed listener should be closed by the mux.
+	conn, err := tcp.Dial("tcp", tcpListener.Addr().String(), 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("unexpected read error: %v", err)
+	}
+
+	// Serve should return once the mux's listener is closed.
+	tcpListener.Close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for mux to close")
+	}
+}
@@ -2,6 +2,7 @@ package toml
 
 import (
 	"fmt"
+	"os"
 	"strconv"
 	"time"
 )
@@ -70,3 +71,27 @@ func (s *Size) UnmarshalText(text []byte) error {
 	*s = Size(size)
 	return nil
 }
+
+// FileMode is a TOML wrapper type for os.FileMode permission bits.
+// Values are written as octal strings, such as "0700".
+type FileMode os.FileMode
+
+// UnmarshalText parses an octal string into a file mode.
+func (m *FileMode) UnmarshalText(text []byte) error {
+	// Ignore if there is no value set.
+	if len(text) == 0 {
+		return nil
+	}
+
+	mode, err := strconv.ParseUint(string(text), 8, 32)
+	if err != nil {
+		return fmt.Errorf("invalid file mode: %s", text)
+	}
+	*m = FileMode(mode)
+	return nil
+}
+
+// MarshalText converts a file mode to an octal string.
+func (m FileMode) MarshalText() (text []byte, err error) {
+	return []byte(fmt.Sprintf("%04o", uint32(m))), nil
+}
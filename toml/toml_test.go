@@ -31,6 +31,26 @@ func TestSize_UnmarshalText_GB(t *testing.T) {
 	}
 }
 
+// Ensure that file modes are parsed as octal and written back the same way.
+func TestFileMode_UnmarshalText(t *testing.T) {
+	var m itoml.FileMode
+	if err := m.UnmarshalText([]byte("0750")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if m != 0750 {
+		t.Fatalf("unexpected mode: %o", m)
+	}
+
+	if b, err := m.MarshalText(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if string(b) != "0750" {
+		t.Fatalf("unexpected text: %s", b)
+	}
+
+	if err := m.UnmarshalText([]byte("0800")); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
 func TestConfig_Encode(t *testing.T) {
 	var c run.Config
 	c.Cluster.WriteTimeout = itoml.Duration(time.Minute)